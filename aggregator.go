@@ -0,0 +1,147 @@
+package colly
+
+import (
+	"sync"
+	"time"
+)
+
+// AggregateCallback is a type alias for the callback functions of an
+// Aggregator. parts contains the values of the record keyed by part name.
+type AggregateCallback func(id string, parts map[string]interface{})
+
+// Aggregator reassembles records whose parts are extracted by different
+// requests, e.g. a listing entry and its detail page, correlated by an ID
+// passed between the requests in their Context:
+//
+//	agg := colly.NewAggregator(time.Minute, func(id string, parts map[string]interface{}) {
+//		save(parts["listing"], parts["detail"])
+//	})
+//	c.OnHTML(".item", func(e *colly.HTMLElement) {
+//		id := e.Attr("data-id")
+//		agg.Expect(id, "listing", "detail")
+//		agg.Add(id, "listing", e.ChildText("h2"))
+//		ctx := colly.NewContext()
+//		ctx.Put("id", id)
+//		c.Request("GET", e.Request.AbsoluteURL(e.ChildAttr("a", "href")), nil, ctx, nil)
+//	})
+//	c.OnHTML("#detail", func(e *colly.HTMLElement) {
+//		agg.Add(e.Request.Ctx.Get("id"), "detail", e.Text)
+//	})
+//
+// It is safe for concurrent use.
+type Aggregator struct {
+	timeout          time.Duration
+	completeCallback AggregateCallback
+	timeoutCallback  AggregateCallback
+	pending          map[string]*aggregate
+	lock             *sync.Mutex
+}
+
+type aggregate struct {
+	expected []string
+	parts    map[string]interface{}
+	timer    *time.Timer
+}
+
+// NewAggregator creates an Aggregator calling onComplete once all the
+// expected parts of a record have been added. Records which are not
+// complete within timeout after their first part was added or declared by
+// Expect are dropped and passed to the OnTimeout callback, including the
+// records of parts added after their record completed. A timeout of 0
+// disables the timeout.
+func NewAggregator(timeout time.Duration, onComplete AggregateCallback) *Aggregator {
+	return &Aggregator{
+		timeout:          timeout,
+		completeCallback: onComplete,
+		pending:          make(map[string]*aggregate),
+		lock:             &sync.Mutex{},
+	}
+}
+
+// OnTimeout registers a function called with the parts added to a record
+// which did not complete within the timeout of the Aggregator
+func (a *Aggregator) OnTimeout(f AggregateCallback) {
+	a.lock.Lock()
+	a.timeoutCallback = f
+	a.lock.Unlock()
+}
+
+// Expect declares the names of the parts of the record id. Parts may be
+// added before they are expected.
+func (a *Aggregator) Expect(id string, parts ...string) {
+	a.lock.Lock()
+	r := a.record(id)
+	r.expected = parts
+	a.complete(id, r)
+}
+
+// Add stores the value of a part of the record id. The OnComplete
+// callback is called by Add once all the expected parts are added.
+func (a *Aggregator) Add(id, part string, value interface{}) {
+	a.lock.Lock()
+	r := a.record(id)
+	r.parts[part] = value
+	a.complete(id, r)
+}
+
+// Pending returns the number of incomplete records
+func (a *Aggregator) Pending() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.pending)
+}
+
+// record returns the pending record id, starting the timeout of new
+// records. a.lock must be held.
+func (a *Aggregator) record(id string) *aggregate {
+	r, ok := a.pending[id]
+	if !ok {
+		r = &aggregate{parts: make(map[string]interface{})}
+		a.pending[id] = r
+		if a.timeout > 0 {
+			r.timer = time.AfterFunc(a.timeout, func() {
+				a.expire(id, r)
+			})
+		}
+	}
+	return r
+}
+
+// complete removes r and calls the OnComplete callback if it has all the
+// expected parts. complete releases a.lock.
+func (a *Aggregator) complete(id string, r *aggregate) {
+	if r.expected == nil {
+		a.lock.Unlock()
+		return
+	}
+	for _, part := range r.expected {
+		if _, ok := r.parts[part]; !ok {
+			a.lock.Unlock()
+			return
+		}
+	}
+	delete(a.pending, id)
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	f := a.completeCallback
+	a.lock.Unlock()
+	if f != nil {
+		f(id, r.parts)
+	}
+}
+
+// expire removes r and calls the OnTimeout callback if r is still pending
+func (a *Aggregator) expire(id string, r *aggregate) {
+	a.lock.Lock()
+	if a.pending[id] != r {
+		a.lock.Unlock()
+		return
+	}
+	delete(a.pending, id)
+	f := a.timeoutCallback
+	a.lock.Unlock()
+	if f != nil {
+		f(id, r.parts)
+	}
+}
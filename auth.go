@@ -0,0 +1,244 @@
+package colly
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// authenticator adds authorization to the requests of a Collector
+type authenticator interface {
+	// authorize sets the authorization header of req
+	authorize(req *http.Request)
+	// challenge handles the "401 Unauthorized" response of req and
+	// reports whether req should be retried
+	challenge(req *http.Request, resp *Response) bool
+}
+
+// authCache is the Cache of collectors with an authenticator. It neither
+// stores nor serves "401 Unauthorized" responses, so they are retried
+// with credentials instead of being answered from the cache.
+type authCache struct {
+	Cache
+}
+
+func (c authCache) Get(key string) (*CachedResponse, bool) {
+	resp, ok := c.Cache.Get(key)
+	if ok && resp.StatusCode == http.StatusUnauthorized {
+		return nil, false
+	}
+	return resp, ok
+}
+
+func (c authCache) Set(key string, resp *CachedResponse) error {
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil
+	}
+	return c.Cache.Set(key, resp)
+}
+
+// SetDigestAuth enables HTTP Digest authentication. The Collector answers
+// the challenge of "401 Unauthorized" responses and retries the request.
+// Subsequent requests to the same host are authorized preemptively using
+// the last challenge of the host. Challenges are kept by host and realm,
+// so they are never answered for other hosts.
+func (c *Collector) SetDigestAuth(username, password string) {
+	c.auth = &digestAuth{
+		username:   username,
+		password:   password,
+		challenges: make(map[digestKey]*digestChallenge),
+		realms:     make(map[string]string),
+		lock:       &sync.Mutex{},
+	}
+}
+
+// SetBearerToken enables Bearer token authorization of the requests to
+// host (e.g. "example.com:8080" if the URL contains a port). Requests to
+// other hosts are not authorized. If refresh is not nil, it is called on
+// "401 Unauthorized" responses of host to obtain a new token and the
+// request is retried with the new token. Concurrent "401 Unauthorized"
+// responses call refresh only once. No Authorization header is sent while
+// the token is empty.
+func (c *Collector) SetBearerToken(host, token string, refresh func() (string, error)) {
+	c.auth = &bearerAuth{
+		host:    host,
+		token:   token,
+		refresh: refresh,
+		lock:    &sync.RWMutex{},
+	}
+}
+
+type bearerAuth struct {
+	host    string
+	token   string
+	refresh func() (string, error)
+	lock    *sync.RWMutex
+}
+
+func (a *bearerAuth) authorize(req *http.Request) {
+	if req.URL.Host != a.host {
+		return
+	}
+	a.lock.RLock()
+	token := a.token
+	a.lock.RUnlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func (a *bearerAuth) challenge(req *http.Request, _ *Response) bool {
+	if a.refresh == nil || req.URL.Host != a.host {
+		return false
+	}
+	// the lock is held during the refresh, so the requests rejected
+	// concurrently are retried with the token of the first refresh
+	a.lock.Lock()
+	if a.token == "" || req.Header.Get("Authorization") == "Bearer "+a.token {
+		token, err := a.refresh()
+		if err != nil || token == "" || token == a.token {
+			a.lock.Unlock()
+			return false
+		}
+		a.token = token
+	}
+	a.lock.Unlock()
+	a.authorize(req)
+	return true
+}
+
+type digestAuth struct {
+	username   string
+	password   string
+	challenges map[digestKey]*digestChallenge
+	// realms are the realms of the last challenges of the hosts
+	realms map[string]string
+	lock   *sync.Mutex
+}
+
+type digestKey struct {
+	host  string
+	realm string
+}
+
+// digestChallenge is a challenge of a host and the number of the
+// requests authorized by it
+type digestChallenge struct {
+	params map[string]string
+	nc     uint32
+}
+
+func (a *digestAuth) authorize(req *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	realm, ok := a.realms[req.URL.Host]
+	if !ok {
+		return
+	}
+	ch := a.challenges[digestKey{req.URL.Host, realm}]
+	ch.nc++
+	req.Header.Set("Authorization", a.header(ch, req.Method, req.URL.RequestURI()))
+}
+
+func (a *digestAuth) challenge(req *http.Request, resp *Response) bool {
+	h := resp.Headers.Get("Www-Authenticate")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "digest ") {
+		return false
+	}
+	params := parseAuthParams(h[7:])
+	if params["nonce"] == "" || req.Header.Get("Authorization") != "" && params["stale"] != "true" {
+		// the credentials were already rejected
+		return false
+	}
+	a.lock.Lock()
+	a.challenges[digestKey{req.URL.Host, params["realm"]}] = &digestChallenge{params: params}
+	a.realms[req.URL.Host] = params["realm"]
+	a.lock.Unlock()
+	a.authorize(req)
+	return true
+}
+
+// header returns the value of the Authorization header
+// computed from the challenge ch
+func (a *digestAuth) header(ch *digestChallenge, method, uri string) string {
+	var newHash func() hash.Hash
+	algorithm := ch.params["algorithm"]
+	switch strings.ToUpper(algorithm) {
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		newHash = md5.New
+	}
+	digest := func(s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	nc := fmt.Sprintf("%08x", ch.nc)
+	cnonce := randomBoundary()[:16]
+	ha1 := digest(a.username + ":" + ch.params["realm"] + ":" + a.password)
+	ha2 := digest(method + ":" + uri)
+	qop := ""
+	for _, q := range strings.Split(ch.params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	var response string
+	if qop != "" {
+		response = digest(ha1 + ":" + ch.params["nonce"] + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = digest(ha1 + ":" + ch.params["nonce"] + ":" + ha2)
+	}
+	h := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		a.username, ch.params["realm"], ch.params["nonce"], uri, response)
+	if algorithm != "" {
+		h += ", algorithm=" + algorithm
+	}
+	if qop != "" {
+		h += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if opaque, ok := ch.params["opaque"]; ok {
+		h += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return h
+}
+
+// parseAuthParams parses the comma separated key=value
+// parameters of a WWW-Authenticate header
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		i := strings.Index(s, "=")
+		if i == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimSpace(s[i+1:])
+		val := ""
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end == -1 {
+				end = len(s) - 1
+			}
+			val = s[1 : end+1]
+			s = s[end+1:]
+			s = strings.TrimPrefix(s, `"`)
+		} else {
+			end := strings.Index(s, ",")
+			if end == -1 {
+				end = len(s)
+			}
+			val = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = val
+	}
+	return params
+}
@@ -0,0 +1,88 @@
+package colly
+
+import (
+	"math"
+	"sync"
+)
+
+type bloomStorage struct {
+	bits   []uint64
+	size   uint64
+	hashes int
+	lock   *sync.Mutex
+}
+
+// NewBloomStorage creates a Storage backed by a bloom filter, whose memory
+// usage is fixed regardless of the number of visited URLs. The filter is
+// sized for expectedURLs URLs with the false positive rate
+// falsePositiveRate, e.g. NewBloomStorage(10000000, 0.001) uses about
+// 18 MB. A false positive reports an unvisited URL as visited, so about
+// falsePositiveRate of the URLs are skipped with ErrAlreadyVisited without
+// being requested. The rate increases if more URLs are visited than
+// expected. Rates outside of (0, 1) are replaced by 0.01. It is safe
+// for concurrent use.
+func NewBloomStorage(expectedURLs int, falsePositiveRate float64) Storage {
+	if expectedURLs < 1 {
+		expectedURLs = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	n := float64(expectedURLs)
+	size := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if size < 64 {
+		size = 64
+	}
+	hashes := int(math.Ceil(float64(size) / n * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &bloomStorage{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+		lock:   &sync.Mutex{},
+	}
+}
+
+// IsVisited reports whether all of the bits of hash are set
+func (s *bloomStorage) IsVisited(hash uint64) bool {
+	h1, h2 := hash, mixHash(hash)|1
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := 0; i < s.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % s.size
+		if s.bits[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Visited sets the bits of hash and reports whether all of them were set
+// before. The bit positions are derived from hash by double hashing.
+func (s *bloomStorage) Visited(hash uint64) bool {
+	h1, h2 := hash, mixHash(hash)|1
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	visited := true
+	for i := 0; i < s.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % s.size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if s.bits[word]&mask == 0 {
+			visited = false
+			s.bits[word] |= mask
+		}
+	}
+	return visited
+}
+
+// mixHash is the finalizer of SplitMix64
+func mixHash(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
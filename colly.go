@@ -3,6 +3,7 @@ package colly
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -53,6 +54,8 @@ type Collector struct {
 	MaxBodySize int
 	// CacheDir specifies a location where GET requests are cached as files.
 	// When it's not defined, caching is disabled.
+	// Cached responses are used without contacting the server, see
+	// SetCache for caches revalidated by conditional requests.
 	CacheDir string
 	// IgnoreRobotsTxt allows the Collector to ignore any restrictions set by
 	// the target host's robots.txt file.  See http://www.robotstxt.org/ for more
@@ -62,20 +65,83 @@ type Collector struct {
 	Id uint32
 	// DetectCharset can enable character encoding detection for non-utf8 response bodies
 	// without explicit charset declaration. This feature uses https://github.com/saintfish/chardet
-	DetectCharset     bool
-	debugger          debug.Debugger
-	visitedURLs       map[uint64]bool
-	robotsMap         map[string]*robotstxt.RobotsData
-	htmlCallbacks     []*htmlCallbackContainer
-	requestCallbacks  []RequestCallback
-	responseCallbacks []ResponseCallback
-	errorCallbacks    []ErrorCallback
-	scrapedCallbacks  []ScrapedCallback
-	requestCount      uint32
-	responseCount     uint32
-	backend           *httpBackend
-	wg                *sync.WaitGroup
-	lock              *sync.RWMutex
+	DetectCharset bool
+	// ParseHiddenContent enables parsing the contents of <noscript> and
+	// <template> elements as HTML, so OnHTML selectors can match them.
+	// See ExpandHiddenContent for more details.
+	ParseHiddenContent bool
+	// ParseComments enables parsing the contents of HTML comments as HTML,
+	// so OnHTML selectors can match commented-out markup.
+	// See ExpandComments for more details.
+	ParseComments bool
+	// IsolateContext gives every request spawned by Request.Visit or
+	// Request.Post its own Context instead of sharing the parent's.
+	// Only the values stored by Context.PutInheritable are copied
+	// to the new Context.
+	IsolateContext        bool
+	dedupBodies           bool
+	dedupCanonical        bool
+	ignoreScheme          bool
+	ignoreFragment        bool
+	ignoreTrailingSlash   bool
+	urlRewrites           []*urlRewrite
+	followMetaRefresh     bool
+	checkLinks            bool
+	headCheck             HeadCheckFunc
+	proxyFunc             ProxyFunc
+	dnsCache              *dnsCache
+	adaptive              *adaptiveLimiter
+	auth                  authenticator
+	latencies             map[string]*latencyHistogram
+	profiling             uint32
+	snapshotDir           string
+	har                   *harRecorder
+	snapshotName          SnapshotNameFunc
+	profile               map[string]*ProfileEntry
+	profileLock           *sync.Mutex
+	noHeadHosts           map[string]bool
+	perHostLimit          int
+	crawlDeadline         time.Time
+	resumeChan            chan struct{}
+	abortCtx              context.Context
+	abort                 context.CancelFunc
+	hostCounts            map[string]int
+	transformFuncs        map[string]TransformFunc
+	results               chan interface{}
+	resultLock            *sync.RWMutex
+	resultSenders         *sync.WaitGroup
+	bodyStore             BodyStore
+	cache                 Cache
+	debugger              debug.Debugger
+	storage               Storage
+	robotsMap             map[string]*robotstxt.RobotsData
+	htmlCallbacks         []*htmlCallbackContainer
+	requestCallbacks      []RequestCallback
+	responseCallbacks     []ResponseCallback
+	errorCallbacks        []ErrorCallback
+	scrapedCallbacks      []ScrapedCallback
+	queueEmptyCallbacks   []QueueEmptyCallback
+	inFlight              int32
+	emptyNotifying        int32
+	emptyAgain            int32
+	duplicateCallbacks    []ResponseCallback
+	requestErrorCallbacks []ErrorCallback
+	linkCheckedCallbacks  []ResponseCallback
+	htmlErrorCallbacks    []HTMLErrorCallback
+	documentCallbacks     []DocumentCallback
+	linkCallbacks         []LinkCallback
+	htmlAllCallbacks      []*htmlAllCallbackContainer
+	middlewares           []Middleware
+	deterministic         bool
+	deterministicRunning  bool
+	deferredVisits        []*deferredVisit
+	requestCount          uint32
+	requestLimit          uint32
+	reservedCount         uint32
+	responseCount         uint32
+	backend               *httpBackend
+	wg                    *sync.WaitGroup
+	lock                  *sync.RWMutex
 }
 
 // RequestCallback is a type alias for OnRequest callback functions
@@ -87,18 +153,144 @@ type ResponseCallback func(*Response)
 // HTMLCallback is a type alias for OnHTML callback functions
 type HTMLCallback func(*HTMLElement)
 
+// HTMLAllCallback is a type alias for OnHTMLAll callback functions
+type HTMLAllCallback func(*goquery.Selection, *Response)
+
 // ErrorCallback is a type alias for OnError callback functions
 type ErrorCallback func(*Response, error)
 
+// HTMLErrorCallback is a type alias for OnHTMLError callback functions
+type HTMLErrorCallback func(*HTMLElement, error)
+
+// DocumentCallback is a type alias for OnDocument callback functions
+type DocumentCallback func(*goquery.Document, *Response)
+
+// LinkCallback is a type alias for OnLink callback functions.
+// It returns the rewritten link and whether the link is visited.
+type LinkCallback func(link string) (string, bool)
+
 // ScrapedCallback is a type alias for OnScraped callback functions
 type ScrapedCallback func(*Response)
 
+// QueueEmptyCallback is a type alias for OnQueueEmpty callback functions
+type QueueEmptyCallback func()
+
+// HeadCheckFunc is a type alias for HeadBeforeGet functions.
+// The returned value decides whether the GET request is made.
+type HeadCheckFunc func(*Response) bool
+
+// RoundTripFunc is a type alias for functions making HTTP requests
+// like http.Client.Do
+type RoundTripFunc func(*http.Request) (*http.Response, error)
+
+// Middleware is a type alias for Use functions wrapping the function
+// which makes the HTTP requests of a collector
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
 // ProxyFunc is a type alias for proxy setter functions.
 type ProxyFunc func(*http.Request) (*url.URL, error)
 
+// Storage keeps track of the URLs visited by a Collector. A Storage can
+// be shared by multiple collectors by SetStorage, so a URL is visited
+// by only one of them. Storages can also implement
+// IsVisited(hash uint64) bool, which reports whether the hash has been
+// stored without storing it, to reject visited URLs before they are
+// counted by the request limits.
+type Storage interface {
+	// Visited stores the hash of a URL and reports whether it has been
+	// stored before. Visited is called concurrently by the requests of
+	// the collectors sharing the Storage, so it must check and store
+	// the hash atomically.
+	Visited(hash uint64) bool
+}
+
+// visitedChecker is implemented by the Storages which can report whether
+// a hash has been stored without storing it, so visited URLs are rejected
+// before they are counted by the request limits
+type visitedChecker interface {
+	IsVisited(hash uint64) bool
+}
+
+type inMemoryStorage struct {
+	hashes map[uint64]bool
+	lock   *sync.Mutex
+}
+
+// NewInMemoryStorage creates the in-memory Storage used by collectors
+// by default. It is safe for concurrent use.
+func NewInMemoryStorage() Storage {
+	return &inMemoryStorage{
+		hashes: make(map[uint64]bool),
+		lock:   &sync.Mutex{},
+	}
+}
+
+func (s *inMemoryStorage) IsVisited(hash uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.hashes[hash]
+}
+
+func (s *inMemoryStorage) Visited(hash uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.hashes[hash] {
+		return true
+	}
+	s.hashes[hash] = true
+	return false
+}
+
+// BodyStore keeps track of the response bodies already seen by a Collector
+// with body deduplication enabled.
+type BodyStore interface {
+	// Seen stores the hash of a response body and reports
+	// whether it has been stored before
+	Seen(hash uint64) bool
+}
+
+type inMemoryBodyStore struct {
+	hashes map[uint64]bool
+	lock   *sync.Mutex
+}
+
+func newInMemoryBodyStore() *inMemoryBodyStore {
+	return &inMemoryBodyStore{
+		hashes: make(map[uint64]bool),
+		lock:   &sync.Mutex{},
+	}
+}
+
+func (s *inMemoryBodyStore) Seen(hash uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.hashes[hash] {
+		return true
+	}
+	s.hashes[hash] = true
+	return false
+}
+
+var jsLocationRegexp = regexp.MustCompile(`(?:window\.|document\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']|location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+
+var metaRefreshRegexp = regexp.MustCompile(`^\s*\d[\d.]*\s*(?:[;,]\s*)?(?:(?i)url\s*=\s*)?(.*?)\s*$`)
+
+type urlRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
 type htmlCallbackContainer struct {
+	Root     string
 	Selector string
 	Function HTMLCallback
+	Priority int
+	Once     bool
+}
+
+type htmlAllCallbackContainer struct {
+	Selector string
+	Function HTMLAllCallback
 }
 
 var collectorCounter uint32
@@ -122,6 +314,30 @@ var (
 	ErrNoCookieJar = errors.New("Cookie jar is not available")
 	// ErrNoPattern is the error type for LimitRules without patterns
 	ErrNoPattern = errors.New("No pattern defined in LimitRule")
+	// ErrRequestLimitReached is the error type for requests exceeding
+	// the limit set by SetRequestLimit
+	ErrRequestLimitReached = errors.New("Request limit reached")
+	// ErrHostLimitReached is the error type for requests exceeding
+	// the per host limit set by SetPerHostLimit
+	ErrHostLimitReached = errors.New("Per host request limit reached")
+	// ErrCrawlDeadlineReached is the error type for requests made
+	// after the deadline set by SetCrawlDeadline
+	ErrCrawlDeadlineReached = errors.New("Crawl deadline reached")
+	// ErrAborted is the error type for requests rejected or
+	// cancelled by Abort
+	ErrAborted = errors.New("Crawl aborted")
+	// ErrLinkDropped is the error type for links dropped
+	// by an OnLink callback
+	ErrLinkDropped = errors.New("Link dropped by OnLink callback")
+	// ErrNoPaginationInfo is the error type for texts without
+	// a match of PaginationInfoRegexp
+	ErrNoPaginationInfo = errors.New("No pagination info found")
+	// ErrHeadCheckFailed is the error type for GET requests
+	// rejected by the HeadBeforeGet function
+	ErrHeadCheckFailed = errors.New("Request rejected by HEAD check")
+	// ErrIncompleteGroup is the error type for values discarded by
+	// Decoder.DiscardIncompleteGroups because of an incomplete group
+	ErrIncompleteGroup = errors.New("Incomplete group")
 )
 
 // NewCollector creates a new Collector instance with default configuration
@@ -136,7 +352,7 @@ func NewCollector() *Collector {
 func (c *Collector) Init() {
 	c.UserAgent = "colly - https://github.com/gocolly/colly"
 	c.MaxDepth = 0
-	c.visitedURLs = make(map[uint64]bool)
+	c.storage = NewInMemoryStorage()
 	c.MaxBodySize = 10 * 1024 * 1024
 	c.backend = &httpBackend{}
 	c.backend.Init()
@@ -146,6 +362,13 @@ func (c *Collector) Init() {
 	c.robotsMap = make(map[string]*robotstxt.RobotsData)
 	c.IgnoreRobotsTxt = true
 	c.Id = atomic.AddUint32(&collectorCounter, 1)
+	c.bodyStore = newInMemoryBodyStore()
+	c.resultLock = &sync.RWMutex{}
+	c.resultSenders = &sync.WaitGroup{}
+	c.profileLock = &sync.Mutex{}
+	c.noHeadHosts = make(map[string]bool)
+	c.hostCounts = make(map[string]int)
+	c.abortCtx, c.abort = context.WithCancel(context.Background())
 }
 
 // Appengine will replace the Collector's backend http.Client
@@ -166,9 +389,34 @@ func (c *Collector) Appengine(req *http.Request) {
 // request to the URL specified in parameter.
 // Visit also calls the previously provided callbacks
 func (c *Collector) Visit(URL string) error {
+	if c.deterministic {
+		return c.visitDeterministic(URL)
+	}
 	return c.scrape(URL, "GET", 1, nil, nil, nil, true)
 }
 
+// VisitAll starts a collecting job for each of the URLs like Visit, in
+// order. It returns the errors of the visits, where the error at index i
+// belongs to urls[i] and is nil if the visit succeeded. Duplicate and
+// disallowed URLs fail with the same errors as Visit.
+func (c *Collector) VisitAll(urls []string) []error {
+	errs := make([]error, len(urls))
+	for i, u := range urls {
+		errs[i] = c.Visit(u)
+	}
+	return errs
+}
+
+// VisitWithContext starts a collecting job like Visit. Cancelling ctx
+// aborts the in-flight HTTP request. ctx is passed to the requests
+// spawned from the response and to retries too, which fail with the
+// error of ctx once it is done.
+func (c *Collector) VisitWithContext(ctx context.Context, URL string) error {
+	collyCtx := NewContext()
+	collyCtx.reqContext = ctx
+	return c.scrape(URL, "GET", 1, nil, collyCtx, nil, true)
+}
+
 // Post starts a collector job by creating a POST request.
 // Post also calls the previously provided callbacks
 func (c *Collector) Post(URL string, requestData map[string]string) error {
@@ -212,8 +460,15 @@ func (c *Collector) SetDebugger(d debug.Debugger) {
 }
 
 func (c *Collector) scrape(u, method string, depth int, requestData io.Reader, ctx *Context, hdr http.Header, checkRevisit bool) error {
+	return c.scrapeChecked(u, method, depth, requestData, ctx, hdr, checkRevisit, nil)
+}
+
+// scrapeChecked is scrape setting *passed to true if the request passes
+// the checks and becomes in flight. passed can be nil.
+func (c *Collector) scrapeChecked(u, method string, depth int, requestData io.Reader, ctx *Context, hdr http.Header, checkRevisit bool, passed *bool) error {
 	c.wg.Add(1)
 	defer c.wg.Done()
+	u = c.rewriteURL(u)
 	if err := c.requestCheck(u, method, depth, checkRevisit); err != nil {
 		return err
 	}
@@ -244,9 +499,70 @@ func (c *Collector) scrape(u, method string, depth int, requestData io.Reader, c
 	} else {
 		req.Header = hdr
 	}
+	if c.abortCtx.Err() != nil {
+		return ErrAborted
+	}
+	if deadline := c.deadline(); !deadline.IsZero() && time.Now().After(deadline) {
+		return ErrCrawlDeadlineReached
+	}
+	if c.isVisited(u, method, checkRevisit) {
+		return ErrAlreadyVisited
+	}
+	if c.perHostLimit > 0 && !c.reserveHostRequest(parsedURL.Host) {
+		return ErrHostLimitReached
+	}
+	if !c.reserveRequest() {
+		c.releaseHostRequest(parsedURL.Host)
+		return ErrRequestLimitReached
+	}
+	if err := c.checkVisited(u, method, checkRevisit); err != nil {
+		c.releaseHostRequest(parsedURL.Host)
+		c.releaseRequest()
+		return err
+	}
+	atomic.AddInt32(&c.inFlight, 1)
+	defer c.finishRequest()
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			c.releaseRequest()
+		}
+	}()
+	if passed != nil {
+		*passed = true
+	}
 	if ctx == nil {
 		ctx = NewContext()
 	}
+	c.resultLock.RLock()
+	if c.results != nil {
+		ctx.setResultSink(c.sendResult)
+	}
+	c.resultLock.RUnlock()
+	c.waitIfPaused(ctx)
+	if c.abortCtx.Err() != nil {
+		return ErrAborted
+	}
+	reqContext := c.abortCtx
+	if ctx.reqContext != nil {
+		if err := ctx.reqContext.Err(); err != nil {
+			return err
+		}
+		var cancel context.CancelFunc
+		reqContext, cancel = context.WithCancel(ctx.reqContext)
+		defer cancel()
+		go func() {
+			select {
+			case <-c.abortCtx.Done():
+				cancel()
+			case <-reqContext.Done():
+			}
+		}()
+	}
+	if len(c.middlewares) > 0 {
+		reqContext = context.WithValue(reqContext, middlewareKey{}, c.middlewares)
+	}
+	req = req.WithContext(reqContext)
 	request := &Request{
 		URL:       parsedURL,
 		Headers:   &req.Header,
@@ -260,13 +576,38 @@ func (c *Collector) scrape(u, method string, depth int, requestData io.Reader, c
 
 	c.handleOnRequest(request)
 
+	if request.Body != requestData {
+		requestData = request.Body
+		bodyReq, err := http.NewRequest(method, req.URL.String(), requestData)
+		if err != nil {
+			return err
+		}
+		bodyReq.Header = req.Header
+		req = bodyReq.WithContext(req.Context())
+		request.Headers = &req.Header
+	}
 	if method == "POST" && req.Header.Get("Content-Type") == "" {
 		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	}
-	response, err := c.backend.Cache(req, c.MaxBodySize, c.CacheDir)
+	if method == "GET" && c.headCheck != nil && !c.checkHead(req, request) {
+		return ErrHeadCheckFailed
+	}
+	if c.auth != nil {
+		c.auth.authorize(req)
+	}
+	if c.checkLinks && method == "GET" {
+		err := c.checkLink(req, request)
+		succeeded = err == nil
+		return err
+	}
+	response, req, err := c.fetch(req, requestData, request)
+	if err == ErrAborted {
+		return err
+	}
 	if err := c.handleOnError(response, err, request, ctx); err != nil {
 		return err
 	}
+	succeeded = true
 	if req.URL.String() != parsedURL.String() {
 		request.URL = req.URL
 		request.Headers = &req.Header
@@ -278,13 +619,31 @@ func (c *Collector) scrape(u, method string, depth int, requestData io.Reader, c
 
 	c.handleOnResponse(response)
 
-	c.handleOnHTML(response)
+	var doc *goquery.Document
+	if (c.dedupBodies && c.isDuplicateBody(response)) || (c.dedupCanonical && c.isDuplicateCanonical(response)) {
+		c.handleOnDuplicateBody(response)
+	} else {
+		doc = c.handleOnHTML(response)
+	}
+
+	if c.followMetaRefresh {
+		c.handleMetaRefresh(response, doc)
+	}
 
 	c.handleOnScraped(response)
 
 	return nil
 }
 
+func (c *Collector) rewriteURL(u string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, r := range c.urlRewrites {
+		u = r.pattern.ReplaceAllString(u, r.replacement)
+	}
+	return u
+}
+
 func (c *Collector) requestCheck(u, method string, depth int, checkRevisit bool) error {
 	if u == "" {
 		return ErrMissingURL
@@ -304,20 +663,166 @@ func (c *Collector) requestCheck(u, method string, depth int, checkRevisit bool)
 			return ErrNoURLFiltersMatch
 		}
 	}
+	return nil
+}
+
+// isVisited reports whether u has been visited without marking it, if
+// the storage supports it
+func (c *Collector) isVisited(u, method string, checkRevisit bool) bool {
+	checker, ok := c.storage.(visitedChecker)
+	if !ok || !checkRevisit || c.AllowURLRevisit || method != "GET" {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(c.visitedKey(u)))
+	return checker.IsVisited(h.Sum64())
+}
+
+// checkVisited marks u as visited and returns ErrAlreadyVisited if it has
+// already been visited. It is called after the request limits, so URLs
+// rejected by the limits can still be visited later.
+func (c *Collector) checkVisited(u, method string, checkRevisit bool) error {
 	if checkRevisit && !c.AllowURLRevisit && method == "GET" {
 		h := fnv.New64a()
-		h.Write([]byte(u))
-		uHash := h.Sum64()
-		c.lock.RLock()
-		visited := c.visitedURLs[uHash]
-		c.lock.RUnlock()
-		if visited {
+		h.Write([]byte(c.visitedKey(u)))
+		if c.storage.Visited(h.Sum64()) {
 			return ErrAlreadyVisited
 		}
+	}
+	return nil
+}
+
+// fetch sends req through the cache, the authentication and the adaptive
+// rate limit of the collector and records its latency. The latency is the
+// time until the response headers are received, so it does not include
+// the delays of the LimitRules and the download of the body. It returns the
+// response and the request which was sent last, which is a retry of req
+// if the authenticator answered a "401 Unauthorized" response.
+func (c *Collector) fetch(req *http.Request, requestData io.Reader, request *Request) (*Response, *http.Request, error) {
+	host, reqContext := req.URL.Host, req.Context()
+	if c.adaptive != nil {
+		c.adaptive.wait(host)
+	}
+	cache, revalidate := c.responseCache()
+	if cache != nil && c.auth != nil {
+		cache = authCache{cache}
+	}
+	start := time.Now()
+	response, err := c.backend.Cache(req, c.MaxBodySize, cache, revalidate)
+	if err == nil && response.StatusCode == http.StatusUnauthorized && c.auth != nil {
+		if retryReq, ok := c.authRetryRequest(req, requestData, response); ok {
+			req = retryReq.WithContext(reqContext)
+			request.Headers = &req.Header
+			response, err = c.backend.Do(req, c.MaxBodySize)
+		}
+	}
+	if err != nil && c.abortCtx.Err() != nil {
+		return nil, req, ErrAborted
+	}
+	if err != nil {
+		return response, req, err
+	}
+	if c.har != nil {
+		c.har.add(req, response, start)
+	}
+	if response.timings == nil {
+		return response, req, err
+	}
+	c.recordLatency(host, response.timings.wait)
+	if c.adaptive != nil {
+		c.adaptive.update(host, response.StatusCode, response.timings.wait)
+	}
+	return response, req, err
+}
+
+// authRetryRequest returns a copy of req to be retried if the
+// authenticator of the collector can answer the "401 Unauthorized"
+// response. The request body is rewound if it is seekable.
+func (c *Collector) authRetryRequest(req *http.Request, requestData io.Reader, resp *Response) (*http.Request, bool) {
+	if requestData != nil {
+		s, ok := requestData.(io.Seeker)
+		if !ok {
+			return nil, false
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return nil, false
+		}
+	}
+	retryReq, err := http.NewRequest(req.Method, req.URL.String(), requestData)
+	if err != nil {
+		return nil, false
+	}
+	for k, v := range req.Header {
+		retryReq.Header[k] = v
+	}
+	if !c.auth.challenge(retryReq, resp) {
+		return nil, false
+	}
+	return retryReq, true
+}
+
+// checkHead makes a HEAD request to the URL of req and reports whether
+// the GET request should be made according to the HeadBeforeGet function.
+// Hosts responding with "405 Method Not Allowed" or "501 Not Implemented"
+// aren't probed again.
+func (c *Collector) checkHead(req *http.Request, request *Request) bool {
+	host := req.URL.Host
+	c.lock.RLock()
+	noHead := c.noHeadHosts[host]
+	c.lock.RUnlock()
+	if noHead {
+		return true
+	}
+	headReq, err := http.NewRequest("HEAD", req.URL.String(), nil)
+	if err != nil {
+		return true
+	}
+	for k, v := range req.Header {
+		headReq.Header[k] = v
+	}
+	headReq = headReq.WithContext(req.Context())
+	resp, err := c.backend.Do(headReq, 0)
+	if err != nil {
+		return true
+	}
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
 		c.lock.Lock()
-		c.visitedURLs[uHash] = true
+		c.noHeadHosts[host] = true
 		c.lock.Unlock()
+		return true
+	}
+	resp.Ctx = request.Ctx
+	resp.Request = request
+	return c.headCheck(resp)
+}
+
+// checkLink requests the headers of req and passes the response to
+// the OnLinkChecked callbacks. req is sent as GET if the host does
+// not support HEAD requests. Both requests are sent by fetch like
+// the requests of the other modes.
+func (c *Collector) checkLink(req *http.Request, request *Request) error {
+	headReq, err := http.NewRequest("HEAD", req.URL.String(), nil)
+	if err != nil {
+		return err
 	}
+	for k, v := range req.Header {
+		headReq.Header[k] = v
+	}
+	headReq = headReq.WithContext(req.Context())
+	response, _, err := c.fetch(headReq, nil, request)
+	if err == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented) {
+		response, _, err = c.fetch(req, nil, request)
+	}
+	if err == ErrAborted {
+		return err
+	}
+	if err != nil {
+		return c.handleOnError(response, err, request, request.Ctx)
+	}
+	atomic.AddUint32(&c.responseCount, 1)
+	response.Ctx = request.Ctx
+	response.Request = request
+	c.handleOnLinkChecked(response)
 	return nil
 }
 
@@ -384,9 +889,64 @@ func (c *Collector) String() string {
 	)
 }
 
-// Wait returns when the collector jobs are finished
+// Wait returns when the collector jobs are finished.
+// Wait also closes the channel returned by Results and writes the
+// HTTP Archive enabled by EnableHAR.
 func (c *Collector) Wait() {
 	c.wg.Wait()
+	c.FlushHAR()
+	c.resultLock.Lock()
+	results := c.results
+	c.results = nil
+	c.resultLock.Unlock()
+	if results != nil {
+		c.resultSenders.Wait()
+		close(results)
+	}
+}
+
+// Results returns a channel which streams the items appended by
+// Context.AppendResult during the crawl. Appending blocks until the
+// item is received, so a slow consumer slows down the crawl instead of
+// losing items. The channel must be consumed concurrently with the
+// collector jobs and it is closed by Wait.
+func (c *Collector) Results() <-chan interface{} {
+	c.resultLock.Lock()
+	defer c.resultLock.Unlock()
+	if c.results == nil {
+		c.results = make(chan interface{})
+	}
+	return c.results
+}
+
+// sendResult sends v to the Results channel if it is in use. The channel
+// is sent to without holding resultLock, so a slow consumer doesn't block
+// Wait and Results. Wait closes the channel after the pending sends.
+func (c *Collector) sendResult(v interface{}) bool {
+	c.resultLock.RLock()
+	results := c.results
+	if results != nil {
+		c.resultSenders.Add(1)
+	}
+	c.resultLock.RUnlock()
+	if results == nil {
+		return false
+	}
+	defer c.resultSenders.Done()
+	results <- v
+	return true
+}
+
+// Use adds a middleware to the chain wrapping the HTTP requests of the
+// collector, e.g. for logging, timing or header mutation. The middleware
+// receives the next function of the chain and returns the function
+// called instead of it. Middlewares are called in the order of their
+// registration, so the first one is the outermost. Redirects are followed
+// by the innermost function, which is the Do method of the HTTP client.
+func (c *Collector) Use(m Middleware) {
+	c.lock.Lock()
+	c.middlewares = append(c.middlewares, m)
+	c.lock.Unlock()
 }
 
 // OnRequest registers a function. Function will be executed on every
@@ -410,15 +970,100 @@ func (c *Collector) OnResponse(f ResponseCallback) {
 	c.lock.Unlock()
 }
 
+// OnResponseContentType registers a function. Function will be executed on
+// every response whose Content-Type header starts with contentType, e.g.
+// "application/json". The comparison is case-insensitive.
+func (c *Collector) OnResponseContentType(contentType string, f ResponseCallback) {
+	contentType = strings.ToLower(contentType)
+	c.OnResponse(func(r *Response) {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(r.Headers.Get("Content-Type"))), contentType) {
+			f(r)
+		}
+	})
+}
+
 // OnHTML registers a function. Function will be executed on every HTML
 // element matched by the GoQuery Selector parameter.
 // GoQuery Selector is a selector used by https://github.com/PuerkitoBio/goquery
+// HTML callbacks are called in the order of their priority (see
+// OnHTMLWithPriority). Callbacks registered by OnHTML have priority 0.
+// Callbacks of equal priority are called in the order of their registration,
+// and every callback is called for all of its matching elements before
+// the next one.
 func (c *Collector) OnHTML(goquerySelector string, f HTMLCallback) {
+	c.addHTMLCallback(&htmlCallbackContainer{
+		Selector: goquerySelector,
+		Function: f,
+	})
+}
+
+// OnHTMLWithPriority registers a function like OnHTML with the given
+// priority. Callbacks with higher priority are called before the ones
+// with lower priority, e.g. a callback storing data in the Context can
+// be registered with priority 1 to run before the OnHTML callbacks
+// reading it.
+func (c *Collector) OnHTMLWithPriority(goquerySelector string, priority int, f HTMLCallback) {
+	c.addHTMLCallback(&htmlCallbackContainer{
+		Selector: goquerySelector,
+		Function: f,
+		Priority: priority,
+	})
+}
+
+// OnHTMLOnce registers a function like OnHTML, but the function is
+// executed only on the first HTML element matched by the GoQuery Selector
+// in every response.
+func (c *Collector) OnHTMLOnce(goquerySelector string, f HTMLCallback) {
+	c.addHTMLCallback(&htmlCallbackContainer{
+		Selector: goquerySelector,
+		Function: f,
+		Once:     true,
+	})
+}
+
+// OnHTMLScoped registers a function. Function will be executed on every
+// HTML element matched by the goquerySelector parameter inside the elements
+// matched by rootSelector. Narrowing the search to a known container saves
+// matching time on large documents.
+// Elements outside of the root (e.g. links in the navigation or footer)
+// are never passed to f, so crawling links of the whole page requires
+// a separate OnHTML callback.
+func (c *Collector) OnHTMLScoped(rootSelector, goquerySelector string, f HTMLCallback) {
+	c.addHTMLCallback(&htmlCallbackContainer{
+		Root:     rootSelector,
+		Selector: goquerySelector,
+		Function: f,
+	})
+}
+
+// addHTMLCallback inserts cc after the HTML callbacks with the same
+// or higher priority
+func (c *Collector) addHTMLCallback(cc *htmlCallbackContainer) {
 	c.lock.Lock()
 	if c.htmlCallbacks == nil {
 		c.htmlCallbacks = make([]*htmlCallbackContainer, 0, 4)
 	}
-	c.htmlCallbacks = append(c.htmlCallbacks, &htmlCallbackContainer{
+	i := len(c.htmlCallbacks)
+	for i > 0 && c.htmlCallbacks[i-1].Priority < cc.Priority {
+		i--
+	}
+	c.htmlCallbacks = append(c.htmlCallbacks, nil)
+	copy(c.htmlCallbacks[i+1:], c.htmlCallbacks[i:])
+	c.htmlCallbacks[i] = cc
+	c.lock.Unlock()
+}
+
+// OnHTMLAll registers a function. Function will be executed once on every
+// HTML page with all the elements matching the GoQuery Selector parameter,
+// e.g. to compute aggregates like the sum of prices. It is not executed
+// on pages without matching elements. OnHTMLAll functions are executed
+// after the OnHTML functions.
+func (c *Collector) OnHTMLAll(goquerySelector string, f HTMLAllCallback) {
+	c.lock.Lock()
+	if c.htmlAllCallbacks == nil {
+		c.htmlAllCallbacks = make([]*htmlAllCallbackContainer, 0, 4)
+	}
+	c.htmlAllCallbacks = append(c.htmlAllCallbacks, &htmlAllCallbackContainer{
 		Selector: goquerySelector,
 		Function: f,
 	})
@@ -442,7 +1087,8 @@ func (c *Collector) OnHTMLDetach(goquerySelector string) {
 }
 
 // OnError registers a function. Function will be executed if an error
-// occurs during the HTTP request.
+// occurs during the HTTP request or the response has an HTTP error status.
+// See OnRequestError to handle transport failures separately.
 func (c *Collector) OnError(f ErrorCallback) {
 	c.lock.Lock()
 	if c.errorCallbacks == nil {
@@ -452,6 +1098,64 @@ func (c *Collector) OnError(f ErrorCallback) {
 	c.lock.Unlock()
 }
 
+// OnDocument registers a function. Function will be executed on every
+// parsed HTML document before the OnHTML callbacks, so it can modify the
+// document (e.g. remove <script> elements or unwrap lazy-load placeholders)
+// and the selectors of the OnHTML callbacks match the modified document.
+func (c *Collector) OnDocument(f DocumentCallback) {
+	c.lock.Lock()
+	if c.documentCallbacks == nil {
+		c.documentCallbacks = make([]DocumentCallback, 0, 4)
+	}
+	c.documentCallbacks = append(c.documentCallbacks, f)
+	c.lock.Unlock()
+}
+
+// OnLink registers a function. Function will be executed on every link
+// visited by Request.Visit, e.g. from OnHTML callbacks, before its
+// request is made. The absolute URL of the link is passed to the function,
+// which returns the URL to visit instead, e.g. without tracking parameters
+// or with https scheme, and false to drop the link. Dropped links are not
+// visited and Request.Visit returns ErrLinkDropped. The functions are
+// called in the order of their registration, each with the link returned
+// by the previous one.
+func (c *Collector) OnLink(f LinkCallback) {
+	c.lock.Lock()
+	if c.linkCallbacks == nil {
+		c.linkCallbacks = make([]LinkCallback, 0, 4)
+	}
+	c.linkCallbacks = append(c.linkCallbacks, f)
+	c.lock.Unlock()
+}
+
+// OnHTMLError registers a function. Function will be executed on every
+// extraction error reported by HTMLElement.ReportError in OnHTML callbacks
+// (e.g. a failed Unmarshal). Extraction errors do not abort the crawl and
+// are not passed to OnError callbacks.
+func (c *Collector) OnHTMLError(f HTMLErrorCallback) {
+	c.lock.Lock()
+	if c.htmlErrorCallbacks == nil {
+		c.htmlErrorCallbacks = make([]HTMLErrorCallback, 0, 4)
+	}
+	c.htmlErrorCallbacks = append(c.htmlErrorCallbacks, f)
+	c.lock.Unlock()
+}
+
+// OnRequestError registers a function. Function will be executed if the
+// HTTP request fails at the transport level (e.g. DNS, connection or
+// timeout errors), so no HTTP response is available.
+// If at least one OnRequestError function is registered, transport
+// failures are not passed to the OnError functions, so OnError only
+// handles HTTP error statuses.
+func (c *Collector) OnRequestError(f ErrorCallback) {
+	c.lock.Lock()
+	if c.requestErrorCallbacks == nil {
+		c.requestErrorCallbacks = make([]ErrorCallback, 0, 4)
+	}
+	c.requestErrorCallbacks = append(c.requestErrorCallbacks, f)
+	c.lock.Unlock()
+}
+
 // OnScraped registers a function. Function will be executed after
 // OnHTML, as a final part of the scraping.
 func (c *Collector) OnScraped(f ScrapedCallback) {
@@ -463,11 +1167,400 @@ func (c *Collector) OnScraped(f ScrapedCallback) {
 	c.lock.Unlock()
 }
 
+// OnQueueEmpty registers a function. Function will be executed every time
+// the last request in flight of the collector finishes, including the
+// requests of its visits spawned by Request.Visit, e.g. to seed more URLs
+// or to finish the crawl. Requests of clones are not counted. The
+// function is not called recursively by the visits it makes: if they
+// have finished when it returns, it is executed again.
+func (c *Collector) OnQueueEmpty(f QueueEmptyCallback) {
+	c.lock.Lock()
+	if c.queueEmptyCallbacks == nil {
+		c.queueEmptyCallbacks = make([]QueueEmptyCallback, 0, 4)
+	}
+	c.queueEmptyCallbacks = append(c.queueEmptyCallbacks, f)
+	c.lock.Unlock()
+}
+
+// AddURLRewrite registers a URL rewrite rule. Every URL is rewritten by
+// the registered rules before it is checked against the filters and the
+// visited URLs and before the request is made. Rules are applied in the
+// order of their registration. The replacement string can contain
+// references to the submatches of pattern (see regexp.Regexp.ReplaceAllString).
+func (c *Collector) AddURLRewrite(pattern *regexp.Regexp, replacement string) {
+	c.lock.Lock()
+	c.urlRewrites = append(c.urlRewrites, &urlRewrite{
+		pattern:     pattern,
+		replacement: replacement,
+	})
+	c.lock.Unlock()
+}
+
+// SetRequestLimit sets the maximum number of successful requests made by
+// the collector. Once the limit is reached, new requests are rejected with
+// ErrRequestLimitReached while the in-flight ones are finished.
+// In-flight requests count against the limit and are uncounted if they
+// fail, i.e. if their error is passed to the OnError callbacks.
+// Rejected URLs are not marked as visited, so they can be visited if the
+// limit is raised. Set it to 0 to disable the limit (default).
+func (c *Collector) SetRequestLimit(n int) {
+	atomic.StoreUint32(&c.requestLimit, uint32(n))
+}
+
+// Pause stops the collector from making new requests until Resume is
+// called. Requests already sent are finished. Visit and the other
+// request methods block while the collector is paused, so Pause is
+// meant to be called from another goroutine than the ones making
+// the requests (e.g. `go c.Visit(u)`).
+// Calling Pause on a paused collector has no effect.
+func (c *Collector) Pause() {
+	c.lock.Lock()
+	if c.resumeChan == nil {
+		c.resumeChan = make(chan struct{})
+	}
+	c.lock.Unlock()
+}
+
+// Resume continues making the requests held back by Pause.
+// Calling Resume on a running collector has no effect.
+func (c *Collector) Resume() {
+	c.lock.Lock()
+	if c.resumeChan != nil {
+		close(c.resumeChan)
+		c.resumeChan = nil
+	}
+	c.lock.Unlock()
+}
+
+// waitIfPaused blocks while the collector is paused or until the
+// context of VisitWithContext is done or the crawl is aborted
+func (c *Collector) waitIfPaused(ctx *Context) {
+	c.lock.RLock()
+	resume := c.resumeChan
+	c.lock.RUnlock()
+	if resume == nil {
+		return
+	}
+	var done <-chan struct{}
+	if ctx.reqContext != nil {
+		done = ctx.reqContext.Done()
+	}
+	select {
+	case <-resume:
+	case <-done:
+	case <-c.abortCtx.Done():
+	}
+}
+
+// Abort stops the crawl, e.g. from a callback once the searched content
+// has been found. New requests of the collector and its clones are
+// rejected with ErrAborted, paused requests are released and in-flight
+// requests are cancelled, so Wait returns promptly. Cancelled requests
+// return ErrAborted without calling the OnError callbacks.
+// An aborted collector cannot be restarted.
+func (c *Collector) Abort() {
+	c.abort()
+}
+
+// SetCrawlDeadline sets the time after which the collector stops making
+// new requests. Requests started before the deadline are finished, so
+// Wait returns once they are done. Later requests are rejected with
+// ErrCrawlDeadlineReached, which is returned by Visit and passed to
+// the callers of Request.Visit.
+// Set it to the zero time to disable the deadline (default).
+func (c *Collector) SetCrawlDeadline(t time.Time) {
+	c.lock.Lock()
+	c.crawlDeadline = t
+	c.lock.Unlock()
+}
+
+func (c *Collector) deadline() time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.crawlDeadline
+}
+
+// SetPerHostLimit sets the maximum number of requests made by the
+// collector to a single host. Requests to hosts which have reached the
+// limit are rejected with ErrHostLimitReached while requests to other
+// hosts continue. Set it to 0 to disable the limit (default).
+func (c *Collector) SetPerHostLimit(n int) {
+	c.perHostLimit = n
+}
+
+// reserveHostRequest counts a request to host and reports whether
+// it fits in the per host limit
+func (c *Collector) reserveHostRequest(host string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.hostCounts[host] >= c.perHostLimit {
+		return false
+	}
+	c.hostCounts[host]++
+	return true
+}
+
+// reserveRequest counts a request and reports whether it fits in the
+// request limit. Requests are counted without a limit as well, so the
+// count is right if the limit is set during the crawl.
+func (c *Collector) reserveRequest() bool {
+	for {
+		n := atomic.LoadUint32(&c.reservedCount)
+		if limit := atomic.LoadUint32(&c.requestLimit); limit > 0 && n >= limit {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(&c.reservedCount, n, n+1) {
+			return true
+		}
+	}
+}
+
+// releaseRequest uncounts a request which has been counted by
+// reserveRequest but has not been made or has failed
+func (c *Collector) releaseRequest() {
+	atomic.AddUint32(&c.reservedCount, ^uint32(0))
+}
+
+// releaseHostRequest uncounts a request to host which has been counted
+// by the per host limit but has not been made
+func (c *Collector) releaseHostRequest(host string) {
+	if c.perHostLimit > 0 {
+		c.lock.Lock()
+		c.hostCounts[host]--
+		c.lock.Unlock()
+	}
+}
+
+// RegisterTransform registers a named string transformation which can be
+// used in the "pipe" struct tags of HTMLElement.Unmarshal.
+// Registered transforms override the built-in ones with the same name.
+func (c *Collector) RegisterTransform(name string, f TransformFunc) {
+	c.lock.Lock()
+	if c.transformFuncs == nil {
+		c.transformFuncs = make(map[string]TransformFunc)
+	}
+	c.transformFuncs[name] = f
+	c.lock.Unlock()
+}
+
+// transforms returns a copy of the registered transforms, so they can be
+// read while transforms are registered concurrently
+func (c *Collector) transforms() map[string]TransformFunc {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return copyTransforms(c.transformFuncs)
+}
+
+func copyTransforms(funcs map[string]TransformFunc) map[string]TransformFunc {
+	if funcs == nil {
+		return nil
+	}
+	m := make(map[string]TransformFunc, len(funcs))
+	for name, f := range funcs {
+		m[name] = f
+	}
+	return m
+}
+
+// SetCheckHead enables or disables link checking mode. In link checking
+// mode the collector requests only the headers of the visited URLs by
+// HEAD requests, falling back to GET if a host responds with 405 Method
+// Not Allowed or 501 Not Implemented. The responses of every status code
+// are passed to the OnLinkChecked callbacks, while OnResponse, OnHTML
+// and OnScraped callbacks are skipped. Transport errors are passed to
+// the error callbacks as usual. POST requests are not affected.
+func (c *Collector) SetCheckHead(enabled bool) {
+	c.checkLinks = enabled
+}
+
+// OnLinkChecked registers a function. Function will be executed on every
+// response received in link checking mode (see SetCheckHead).
+func (c *Collector) OnLinkChecked(f ResponseCallback) {
+	c.lock.Lock()
+	if c.linkCheckedCallbacks == nil {
+		c.linkCheckedCallbacks = make([]ResponseCallback, 0, 4)
+	}
+	c.linkCheckedCallbacks = append(c.linkCheckedCallbacks, f)
+	c.lock.Unlock()
+}
+
+// OnDuplicateBody registers a function. Function will be executed instead
+// of the OnHTML callbacks if body deduplication is enabled and the body of
+// the response is identical to a previously seen one, or if deduplication
+// by canonical URL is enabled and the canonical URL of the page has
+// already been visited.
+func (c *Collector) OnDuplicateBody(f ResponseCallback) {
+	c.lock.Lock()
+	if c.duplicateCallbacks == nil {
+		c.duplicateCallbacks = make([]ResponseCallback, 0, 4)
+	}
+	c.duplicateCallbacks = append(c.duplicateCallbacks, f)
+	c.lock.Unlock()
+}
+
+// DeduplicateBodies enables or disables response body deduplication.
+// If it is enabled, OnHTML callbacks are skipped for responses whose body
+// has already been seen by the collector (e.g. soft-404s or mirror pages)
+// and OnDuplicateBody callbacks are called instead.
+func (c *Collector) DeduplicateBodies(enable bool) {
+	c.dedupBodies = enable
+}
+
+// DeduplicateByCanonical enables or disables deduplication of HTML pages
+// by their canonical URL declared by <link rel="canonical">. If it is
+// enabled, the canonical URL of every page is marked as visited, and the
+// OnHTML callbacks of pages whose canonical URL has already been visited
+// are skipped. OnDuplicateBody callbacks are called for them instead.
+// Finding the canonical URL requires an additional parse of the page.
+func (c *Collector) DeduplicateByCanonical(enable bool) {
+	c.dedupCanonical = enable
+}
+
+// IgnoreScheme enables or disables ignoring the scheme of URLs when
+// checking whether they have been visited, so the http:// and https://
+// versions of a URL are treated as identical.
+func (c *Collector) IgnoreScheme(enable bool) {
+	c.ignoreScheme = enable
+}
+
+// IgnoreFragment enables or disables ignoring the fragment of URLs when
+// checking whether they have been visited, e.g. "/page#top" is treated
+// as identical to "/page".
+func (c *Collector) IgnoreFragment(enable bool) {
+	c.ignoreFragment = enable
+}
+
+// IgnoreTrailingSlash enables or disables ignoring the trailing slashes
+// of URL paths when checking whether they have been visited, e.g.
+// "/docs/" is treated as identical to "/docs".
+func (c *Collector) IgnoreTrailingSlash(enable bool) {
+	c.ignoreTrailingSlash = enable
+}
+
+// visitedKey returns the key of u in the visited URL storage according
+// to IgnoreScheme, IgnoreFragment and IgnoreTrailingSlash
+func (c *Collector) visitedKey(u string) string {
+	if !c.ignoreScheme && !c.ignoreFragment && !c.ignoreTrailingSlash {
+		return u
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	if c.ignoreScheme {
+		parsed.Scheme = ""
+	}
+	if c.ignoreFragment {
+		parsed.Fragment = ""
+	}
+	if c.ignoreTrailingSlash {
+		parsed.Path = strings.TrimRight(parsed.Path, "/")
+		parsed.RawPath = strings.TrimRight(parsed.RawPath, "/")
+	}
+	return parsed.String()
+}
+
+// FollowMetaRefresh enables or disables following the redirects declared by
+// <meta http-equiv="refresh"> tags or by trivial JavaScript location
+// assignments (e.g. window.location = "/next") in HTML responses.
+// The redirect targets are visited as child requests of the response,
+// so MaxDepth and URL revisit checks apply to them.
+// JavaScript redirects are detected on a best-effort basis.
+func (c *Collector) FollowMetaRefresh(enable bool) {
+	c.followMetaRefresh = enable
+}
+
+// HeadBeforeGet enables probing the URLs of GET requests by a HEAD request
+// before downloading them. The GET request is made only if f returns true
+// for the response of the HEAD request, otherwise ErrHeadCheckFailed is
+// returned. It is useful to avoid downloading unwanted content types.
+// Hosts which don't support HEAD requests are fetched by GET directly.
+// Set f to nil to disable probing (default).
+func (c *Collector) HeadBeforeGet(f HeadCheckFunc) {
+	c.headCheck = f
+}
+
+// SetStorage overrides the default in-memory storage of the visited URLs.
+// Collectors sharing a Storage never visit the same URL twice, even if
+// they make requests concurrently: the first request to a URL marks it
+// as visited before it is sent, and the requests of the other collectors
+// to the URL fail with ErrAlreadyVisited. URLs are not unmarked if their
+// request fails, so they are not retried by the other collectors.
+// Clone creates collectors with their own in-memory storage, so call
+// SetStorage on the clones to share it.
+func (c *Collector) SetStorage(s Storage) {
+	c.storage = s
+}
+
+// SetBodyStore overrides the default in-memory storage of response
+// body hashes used by DeduplicateBodies
+func (c *Collector) SetBodyStore(s BodyStore) {
+	c.bodyStore = s
+}
+
+// SetCache sets a Cache storing the responses of GET requests, e.g. one
+// backed by Redis. Stored responses are revalidated by conditional
+// requests using their ETag and Last-Modified headers, and they are
+// served from the Cache if the server responds with 304 Not Modified.
+// Use NewFileCache to revalidate responses cached as files.
+// SetCache overrides CacheDir, set cache to nil to use CacheDir again.
+func (c *Collector) SetCache(cache Cache) {
+	c.cache = cache
+}
+
+// responseCache returns the Cache of the requests and whether its
+// responses are revalidated
+func (c *Collector) responseCache() (Cache, bool) {
+	if c.cache != nil {
+		return c.cache, true
+	}
+	if c.CacheDir != "" {
+		return NewFileCache(c.CacheDir), false
+	}
+	return nil, false
+}
+
 // WithTransport allows you to set a custom http.RoundTripper (transport)
+// to tune connection pooling, dialing or HTTP/2 settings.
+// Cookies, redirects, timeouts and authentication are handled by the
+// HTTP client of the collector above the transport, so they apply to
+// custom transports too. The proxy function set by SetProxy or
+// SetProxyFunc is applied to transport if it is an *http.Transport
+// without a proxy function of its own. Proxies set after WithTransport
+// replace transport unless it is an *http.Transport.
 func (c *Collector) WithTransport(transport http.RoundTripper) {
+	if t, ok := transport.(*http.Transport); ok && t.Proxy == nil && c.proxyFunc != nil {
+		t.Proxy = c.proxyFunc
+	}
 	c.backend.Client.Transport = transport
 }
 
+// EnableDNSCache caches the resolved addresses of hosts for ttl, so
+// repeated connections to the same host skip DNS resolution. The cache
+// is installed into the current transport if it is an *http.Transport,
+// otherwise the transport is replaced by a new *http.Transport using
+// the proxy set by SetProxy or SetProxyFunc.
+// Calling EnableDNSCache again replaces the cache.
+func (c *Collector) EnableDNSCache(ttl time.Duration) {
+	c.dnsCache = newDNSCache(ttl)
+	t, ok := c.backend.Client.Transport.(*http.Transport)
+	if !ok {
+		t = &http.Transport{
+			Proxy: c.proxyFunc,
+		}
+		c.backend.Client.Transport = t
+	}
+	t.DialContext = c.dnsCache.dialContext
+}
+
+// ClearDNSCache removes every entry of the DNS cache enabled by
+// EnableDNSCache
+func (c *Collector) ClearDNSCache() {
+	if c.dnsCache != nil {
+		c.dnsCache.clear()
+	}
+}
+
 // DisableCookies turns off cookie handling
 func (c *Collector) DisableCookies() {
 	c.backend.Client.Jar = nil
@@ -501,12 +1594,16 @@ func (c *Collector) SetProxy(proxyURL string) error {
 
 // SetProxyFunc sets a custom proxy setter/switcher function.
 // See built-in ProxyFuncs for more details.
+// The function is called for every request, so it can select the proxy
+// by the target of the request, e.g. from per domain proxy pools.
+// Requests are made directly if it returns a nil URL.
 // This method overrides the previously used http.Transport
 // if the type of the transport is not http.RoundTripper.
 // The proxy type is determined by the URL scheme. "http"
 // and "socks5" are supported. If the scheme is empty,
 // "http" is assumed.
 func (c *Collector) SetProxyFunc(p ProxyFunc) {
+	c.proxyFunc = p
 	t, ok := c.backend.Client.Transport.(*http.Transport)
 	if c.backend.Client.Transport != nil && ok {
 		t.Proxy = p
@@ -549,18 +1646,67 @@ func (c *Collector) handleOnResponse(r *Response) {
 	}
 }
 
-func (c *Collector) handleOnHTML(resp *Response) {
-	if !strings.Contains(strings.ToLower(resp.Headers.Get("Content-Type")), "html") || len(c.htmlCallbacks) == 0 {
-		return
+// handleOnLink returns the link rewritten by the OnLink callbacks and
+// whether it is visited
+func (c *Collector) handleOnLink(link string) (string, bool) {
+	if link == "" {
+		return link, true
+	}
+	for _, f := range c.linkCallbacks {
+		var ok bool
+		if link, ok = f(link); !ok {
+			return link, false
+		}
+	}
+	return link, true
+}
+
+// handleOnHTML calls the HTML callbacks and returns the parsed document,
+// or nil if the response has not been parsed
+func (c *Collector) handleOnHTML(resp *Response) *goquery.Document {
+	if !strings.Contains(strings.ToLower(resp.Headers.Get("Content-Type")), "html") || (len(c.htmlCallbacks) == 0 && len(c.documentCallbacks) == 0 && len(c.htmlAllCallbacks) == 0 && c.snapshotDir == "") {
+		return nil
 	}
 	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer(resp.Body))
 	if err != nil {
-		return
+		return nil
+	}
+	if href, found := doc.Find("base[href]").Attr("href"); found {
+		if base, err := resp.Request.URL.Parse(href); err == nil {
+			resp.Request.baseURL = base
+		}
+	}
+	if c.ParseHiddenContent {
+		ExpandHiddenContent(doc.Selection)
+	}
+	if c.ParseComments {
+		ExpandComments(doc.Selection)
+	}
+	for _, f := range c.documentCallbacks {
+		f(doc, resp)
+	}
+	if c.snapshotDir != "" {
+		if err := c.saveSnapshot(doc, resp); err != nil {
+			c.handleOnHTMLError(NewHTMLElementFromSelectionNode(resp, doc.Selection, doc.Nodes[0]), err)
+		}
 	}
 	for _, cc := range c.htmlCallbacks {
-		doc.Find(cc.Selector).Each(func(i int, s *goquery.Selection) {
+		var start time.Time
+		if c.isProfiling() {
+			start = time.Now()
+		}
+		root := doc.Selection
+		if cc.Root != "" {
+			root = doc.Find(cc.Root)
+		}
+		matches := root.Find(cc.Selector)
+		if cc.Once {
+			matches = matches.First()
+		}
+		matches.Each(func(i int, s *goquery.Selection) {
 			for _, n := range s.Nodes {
 				e := NewHTMLElementFromSelectionNode(resp, s, n)
+				e.Index = i
 				if c.debugger != nil {
 					c.debugger.Event(createEvent("html", resp.Request.Id, c.Id, map[string]string{
 						"selector": cc.Selector,
@@ -570,6 +1716,159 @@ func (c *Collector) handleOnHTML(resp *Response) {
 				cc.Function(e)
 			}
 		})
+		if c.isProfiling() {
+			c.recordProfile(cc.Selector, time.Since(start))
+		}
+	}
+	for _, cc := range c.htmlAllCallbacks {
+		if matches := doc.Find(cc.Selector); matches.Length() > 0 {
+			cc.Function(matches, resp)
+		}
+	}
+	return doc
+}
+
+// handleMetaRefresh follows the redirect declared by resp. doc is the
+// document parsed by handleOnHTML, the body is parsed again if it is nil.
+func (c *Collector) handleMetaRefresh(resp *Response, doc *goquery.Document) {
+	if !strings.Contains(strings.ToLower(resp.Headers.Get("Content-Type")), "html") {
+		return
+	}
+	if doc == nil {
+		var err error
+		if doc, err = goquery.NewDocumentFromReader(bytes.NewBuffer(resp.Body)); err != nil {
+			return
+		}
+	}
+	target := ""
+	doc.Find("meta[http-equiv]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if !strings.EqualFold(s.AttrOr("http-equiv", ""), "refresh") {
+			return true
+		}
+		target = parseMetaRefresh(s.AttrOr("content", ""))
+		return target == ""
+	})
+	if target == "" {
+		doc.Find("script").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+			if m := jsLocationRegexp.FindStringSubmatch(s.Text()); m != nil {
+				target = m[1] + m[2]
+			}
+			return target == ""
+		})
+	}
+	if target != "" {
+		resp.Request.Visit(target)
+	}
+}
+
+// parseMetaRefresh returns the URL of a meta refresh content value
+// like "5; url=http://example.com/". The delay is followed by a ";" or
+// "," and the URL, optionally prefixed by "url=" and quoted. Everything
+// after the prefix belongs to the URL, so it can contain commas.
+func parseMetaRefresh(content string) string {
+	m := metaRefreshRegexp.FindStringSubmatch(content)
+	if m == nil {
+		return ""
+	}
+	u := m[1]
+	if u != "" && (u[0] == '"' || u[0] == '\'') {
+		if i := strings.IndexByte(u[1:], u[0]); i != -1 {
+			return u[1 : i+1]
+		}
+		return u[1:]
+	}
+	return u
+}
+
+func (c *Collector) isDuplicateBody(r *Response) bool {
+	h := fnv.New64a()
+	h.Write(r.Body)
+	return c.bodyStore.Seen(h.Sum64())
+}
+
+// isDuplicateCanonical marks the canonical URL of r as visited and
+// reports whether it has already been visited
+func (c *Collector) isDuplicateCanonical(r *Response) bool {
+	if !strings.Contains(strings.ToLower(r.Headers.Get("Content-Type")), "html") {
+		return false
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer(r.Body))
+	if err != nil {
+		return false
+	}
+	canonical := r.Request.AbsoluteURL(doc.Find(`link[rel="canonical"]`).AttrOr("href", ""))
+	if canonical == "" || c.visitedKey(canonical) == c.visitedKey(r.Request.URL.String()) {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(c.visitedKey(canonical)))
+	return c.storage.Visited(h.Sum64())
+}
+
+// finishRequest calls the OnQueueEmpty callbacks if the finished request
+// was the last one in flight. Requests rejected by the checks preceding
+// the request are not in flight. Requests finishing while the callbacks run
+// make the running notification call them again instead.
+func (c *Collector) finishRequest() {
+	if atomic.AddInt32(&c.inFlight, -1) != 0 {
+		return
+	}
+	c.lock.RLock()
+	callbacks := c.queueEmptyCallbacks
+	c.lock.RUnlock()
+	if len(callbacks) == 0 {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.emptyNotifying, 0, 1) {
+		atomic.StoreInt32(&c.emptyAgain, 1)
+		return
+	}
+	for {
+		for _, f := range callbacks {
+			f()
+		}
+		atomic.StoreInt32(&c.emptyNotifying, 0)
+		if atomic.SwapInt32(&c.emptyAgain, 0) == 0 || atomic.LoadInt32(&c.inFlight) != 0 {
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&c.emptyNotifying, 0, 1) {
+			return
+		}
+	}
+}
+
+func (c *Collector) handleOnDuplicateBody(r *Response) {
+	if c.debugger != nil {
+		c.debugger.Event(createEvent("duplicate", r.Request.Id, c.Id, map[string]string{
+			"url": r.Request.URL.String(),
+		}))
+	}
+	for _, f := range c.duplicateCallbacks {
+		f(r)
+	}
+}
+
+func (c *Collector) handleOnHTMLError(e *HTMLElement, err error) {
+	if c.debugger != nil {
+		c.debugger.Event(createEvent("html_error", e.Request.Id, c.Id, map[string]string{
+			"url":   e.Request.URL.String(),
+			"error": err.Error(),
+		}))
+	}
+	for _, f := range c.htmlErrorCallbacks {
+		f(e, err)
+	}
+}
+
+func (c *Collector) handleOnLinkChecked(r *Response) {
+	if c.debugger != nil {
+		c.debugger.Event(createEvent("link", r.Request.Id, c.Id, map[string]string{
+			"url":    r.Request.URL.String(),
+			"status": http.StatusText(r.StatusCode),
+		}))
+	}
+	for _, f := range c.linkCheckedCallbacks {
+		f(r)
 	}
 }
 
@@ -577,6 +1876,7 @@ func (c *Collector) handleOnError(response *Response, err error, request *Reques
 	if err == nil && response.StatusCode < 203 {
 		return nil
 	}
+	transportError := err != nil
 	if err == nil {
 		err = errors.New(http.StatusText(response.StatusCode))
 	}
@@ -595,7 +1895,11 @@ func (c *Collector) handleOnError(response *Response, err error, request *Reques
 	if response.Request == nil {
 		response.Request = request
 	}
-	for _, f := range c.errorCallbacks {
+	callbacks := c.errorCallbacks
+	if transportError && len(c.requestErrorCallbacks) > 0 {
+		callbacks = c.requestErrorCallbacks
+	}
+	for _, f := range callbacks {
 		f(response, err)
 	}
 	return err
@@ -622,7 +1926,23 @@ func (c *Collector) Limits(rules []*LimitRule) error {
 	return c.backend.Limits(rules)
 }
 
-// SetCookies handles the receipt of the cookies in a reply for the given URL
+// SetBandwidthLimit limits the download throughput of the collector and
+// its clones to bytesPerSec bytes per second, e.g. 1 << 20 for 1 MB/s,
+// by slowing down the reading of the response bodies. The limit is
+// shared by all the requests in flight, so the parallel requests allowed
+// by the LimitRules get a share of the bandwidth each. The time spent
+// waiting for the limit is not counted in the response times of
+// HostStats, the adaptive rate limit and the HAR log. Values below 1
+// remove the limit.
+func (c *Collector) SetBandwidthLimit(bytesPerSec int) {
+	c.backend.SetBandwidthLimit(bytesPerSec)
+}
+
+// SetCookies handles the receipt of the cookies in a reply for the given URL,
+// e.g. to resume a session captured from a browser before crawling.
+// URL must be an absolute http or https URL, and the Domain attributes
+// of the cookies must match its host, otherwise the cookies would be
+// dropped by the jar silently and SetCookies returns an error instead.
 func (c *Collector) SetCookies(URL string, cookies []*http.Cookie) error {
 	if c.backend.Client.Jar == nil {
 		return ErrNoCookieJar
@@ -631,6 +1951,16 @@ func (c *Collector) SetCookies(URL string, cookies []*http.Cookie) error {
 	if err != nil {
 		return err
 	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Invalid cookie URL: " + URL)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, cookie := range cookies {
+		domain := strings.TrimPrefix(strings.ToLower(cookie.Domain), ".")
+		if domain != "" && host != domain && !strings.HasSuffix(host, "."+domain) {
+			return errors.New("Cookie domain " + cookie.Domain + " does not match " + host)
+		}
+	}
 	c.backend.Client.Jar.SetCookies(u, cookies)
 	return nil
 }
@@ -652,26 +1982,101 @@ func (c *Collector) Cookies(URL string) []*http.Cookie {
 // between collectors.
 func (c *Collector) Clone() *Collector {
 	return &Collector{
-		AllowedDomains:    c.AllowedDomains,
-		CacheDir:          c.CacheDir,
-		DisallowedDomains: c.DisallowedDomains,
-		Id:                atomic.AddUint32(&collectorCounter, 1),
-		IgnoreRobotsTxt:   c.IgnoreRobotsTxt,
-		MaxBodySize:       c.MaxBodySize,
-		MaxDepth:          c.MaxDepth,
-		URLFilters:        c.URLFilters,
-		UserAgent:         c.UserAgent,
-		backend:           c.backend,
-		debugger:          c.debugger,
-		errorCallbacks:    make([]ErrorCallback, 0, 8),
-		htmlCallbacks:     make([]*htmlCallbackContainer, 0, 8),
-		lock:              c.lock,
-		requestCallbacks:  make([]RequestCallback, 0, 8),
-		responseCallbacks: make([]ResponseCallback, 0, 8),
-		robotsMap:         c.robotsMap,
-		visitedURLs:       make(map[uint64]bool),
-		wg:                c.wg,
+		AllowedDomains:      c.AllowedDomains,
+		CacheDir:            c.CacheDir,
+		DisallowedDomains:   c.DisallowedDomains,
+		Id:                  atomic.AddUint32(&collectorCounter, 1),
+		IgnoreRobotsTxt:     c.IgnoreRobotsTxt,
+		IsolateContext:      c.IsolateContext,
+		MaxBodySize:         c.MaxBodySize,
+		MaxDepth:            c.MaxDepth,
+		ParseComments:       c.ParseComments,
+		ParseHiddenContent:  c.ParseHiddenContent,
+		URLFilters:          c.URLFilters,
+		UserAgent:           c.UserAgent,
+		abort:               c.abort,
+		abortCtx:            c.abortCtx,
+		adaptive:            c.adaptive,
+		auth:                c.auth,
+		backend:             c.backend,
+		bodyStore:           c.bodyStore,
+		cache:               c.cache,
+		checkLinks:          c.checkLinks,
+		crawlDeadline:       c.deadline(),
+		debugger:            c.debugger,
+		dnsCache:            c.dnsCache,
+		dedupBodies:         c.dedupBodies,
+		dedupCanonical:      c.dedupCanonical,
+		errorCallbacks:      make([]ErrorCallback, 0, 8),
+		followMetaRefresh:   c.followMetaRefresh,
+		har:                 c.har,
+		headCheck:           c.headCheck,
+		ignoreFragment:      c.ignoreFragment,
+		ignoreScheme:        c.ignoreScheme,
+		ignoreTrailingSlash: c.ignoreTrailingSlash,
+		htmlCallbacks:       make([]*htmlCallbackContainer, 0, 8),
+		lock:                c.lock,
+		middlewares:         append([]Middleware(nil), c.middlewares...),
+		hostCounts:          make(map[string]int),
+		noHeadHosts:         c.noHeadHosts,
+		perHostLimit:        c.perHostLimit,
+		profileLock:         &sync.Mutex{},
+		proxyFunc:           c.proxyFunc,
+		requestCallbacks:    make([]RequestCallback, 0, 8),
+		requestLimit:        atomic.LoadUint32(&c.requestLimit),
+		resultLock:          &sync.RWMutex{},
+		resultSenders:       &sync.WaitGroup{},
+		responseCallbacks:   make([]ResponseCallback, 0, 8),
+		robotsMap:           c.robotsMap,
+		snapshotDir:         c.snapshotDir,
+		snapshotName:        c.snapshotName,
+		transformFuncs:      c.transforms(),
+		urlRewrites:         append([]*urlRewrite(nil), c.urlRewrites...),
+		storage:             NewInMemoryStorage(),
+		wg:                  c.wg,
+	}
+}
+
+// Merge appends the callbacks of other to the callbacks of c, e.g. to
+// combine scraping modules developed as separate collectors into one
+// crawl. The callbacks of other are called after the callbacks of c,
+// except that OnHTML callbacks are ordered by their priorities.
+// Only callbacks are merged; the configuration of c is kept.
+func (c *Collector) Merge(other *Collector) {
+	other.lock.RLock()
+	htmlCallbacks := append([]*htmlCallbackContainer(nil), other.htmlCallbacks...)
+	requestCallbacks := append([]RequestCallback(nil), other.requestCallbacks...)
+	responseCallbacks := append([]ResponseCallback(nil), other.responseCallbacks...)
+	errorCallbacks := append([]ErrorCallback(nil), other.errorCallbacks...)
+	scrapedCallbacks := append([]ScrapedCallback(nil), other.scrapedCallbacks...)
+	duplicateCallbacks := append([]ResponseCallback(nil), other.duplicateCallbacks...)
+	requestErrorCallbacks := append([]ErrorCallback(nil), other.requestErrorCallbacks...)
+	linkCheckedCallbacks := append([]ResponseCallback(nil), other.linkCheckedCallbacks...)
+	htmlErrorCallbacks := append([]HTMLErrorCallback(nil), other.htmlErrorCallbacks...)
+	documentCallbacks := append([]DocumentCallback(nil), other.documentCallbacks...)
+	linkCallbacks := append([]LinkCallback(nil), other.linkCallbacks...)
+	htmlAllCallbacks := append([]*htmlAllCallbackContainer(nil), other.htmlAllCallbacks...)
+	queueEmptyCallbacks := append([]QueueEmptyCallback(nil), other.queueEmptyCallbacks...)
+	other.lock.RUnlock()
+
+	for _, cc := range htmlCallbacks {
+		merged := *cc
+		c.addHTMLCallback(&merged)
 	}
+	c.lock.Lock()
+	c.requestCallbacks = append(c.requestCallbacks, requestCallbacks...)
+	c.responseCallbacks = append(c.responseCallbacks, responseCallbacks...)
+	c.errorCallbacks = append(c.errorCallbacks, errorCallbacks...)
+	c.scrapedCallbacks = append(c.scrapedCallbacks, scrapedCallbacks...)
+	c.duplicateCallbacks = append(c.duplicateCallbacks, duplicateCallbacks...)
+	c.requestErrorCallbacks = append(c.requestErrorCallbacks, requestErrorCallbacks...)
+	c.linkCheckedCallbacks = append(c.linkCheckedCallbacks, linkCheckedCallbacks...)
+	c.htmlErrorCallbacks = append(c.htmlErrorCallbacks, htmlErrorCallbacks...)
+	c.documentCallbacks = append(c.documentCallbacks, documentCallbacks...)
+	c.linkCallbacks = append(c.linkCallbacks, linkCallbacks...)
+	c.htmlAllCallbacks = append(c.htmlAllCallbacks, htmlAllCallbacks...)
+	c.queueEmptyCallbacks = append(c.queueEmptyCallbacks, queueEmptyCallbacks...)
+	c.lock.Unlock()
 }
 
 func (c *Collector) checkRedirectFunc() func(req *http.Request, via []*http.Request) error {
@@ -2,12 +2,24 @@ package colly
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -75,10 +87,104 @@ func init() {
 		http.Redirect(w, r, "/redirected/", http.StatusSeeOther)
 
 	}))
+	http.Handle("/redirect_chain", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect", http.StatusFound)
+	}))
 	http.Handle("/redirected/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, `<a href="test">test</a>`)
 	}))
 
+	http.HandleFunc("/meta_refresh", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><meta http-equiv="refresh" content="0; url=/js_redirect"></head></html>`))
+	})
+
+	http.HandleFunc("/js_redirect", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><script>window.location.href = "/html";</script></body></html>`))
+	})
+
+	http.HandleFunc("/bearer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	http.HandleFunc("/digest", func(w http.ResponseWriter, r *http.Request) {
+		params := parseAuthParams(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "))
+		md5hex := func(s string) string {
+			h := md5.Sum([]byte(s))
+			return hex.EncodeToString(h[:])
+		}
+		ha1 := md5hex("user:colly:pass")
+		ha2 := md5hex(r.Method + ":" + params["uri"])
+		expected := md5hex(ha1 + ":testnonce:" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+		if params["response"] != expected {
+			w.Header().Set("WWW-Authenticate", `Digest realm="colly", qop="auth", nonce="testnonce", opaque="o"`)
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	http.HandleFunc("/latin1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=iso-8859-1")
+		w.Write([]byte("caf\xe9"))
+	})
+
+	http.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		w.Write([]byte("slow"))
+	})
+
+	http.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("unavailable"))
+	})
+
+	http.HandleFunc("/no_head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	http.HandleFunc("/canonical", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><link rel="canonical" href="/html"></head><body><p>copy</p></body></html>`))
+	})
+
+	http.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("cached"))
+	})
+
+	http.HandleFunc("/base", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><base href="/docs/"></head><body><a href="page">1</a></body></html>`))
+	})
+
+	http.HandleFunc("/entities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>A &amp; B</title></head>\n<body>\n<p>\"Fish\" &#38; 'Chips'&nbsp;&lt;b&gt;</p><!-- <p>x</p> --><pre>\nx &gt; y</pre></body></html>"))
+	})
+
+	http.HandleFunc("/links", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="/html">1</a><a href="/canonical">2</a><a href="/">3</a></body></html>`))
+	})
+
 	http.HandleFunc("/set_cookie", func(w http.ResponseWriter, r *http.Request) {
 		c := &http.Cookie{Name: "test", Value: "testv", HttpOnly: false}
 		http.SetCookie(w, c)
@@ -222,6 +328,29 @@ func TestCollectorURLRevisit(t *testing.T) {
 	}
 }
 
+func TestCollectorIgnoreURLParts(t *testing.T) {
+	c := NewCollector()
+	c.IgnoreScheme(true)
+	c.IgnoreFragment(true)
+	c.IgnoreTrailingSlash(true)
+
+	visitCount := 0
+	c.OnRequest(func(r *Request) {
+		visitCount++
+	})
+
+	u, _ := url.Parse(testServerRootURL + "html")
+	c.Visit(u.String())
+	for _, dup := range []string{u.String() + "#top", u.String() + "/", "https://" + u.Host + u.Path} {
+		if err := c.Visit(dup); err != ErrAlreadyVisited {
+			t.Errorf("Invalid error for %s: %v, expected %v", dup, err, ErrAlreadyVisited)
+		}
+	}
+	if visitCount != 1 {
+		t.Errorf("Invalid number of visits: %d", visitCount)
+	}
+}
+
 func TestCollectorPost(t *testing.T) {
 	postValue := "hello"
 	c := NewCollector()
@@ -248,6 +377,65 @@ func TestRedirect(t *testing.T) {
 	c.Visit(testServerRootURL + "redirect")
 }
 
+func TestRedirectChainURL(t *testing.T) {
+	c := NewCollector()
+
+	var finalURL string
+	c.OnHTML("a[href]", func(e *HTMLElement) {
+		page := struct {
+			URL string `attr:"#url"`
+		}{}
+		if err := e.Unmarshal(&page); err != nil {
+			t.Error(err)
+		}
+		finalURL = page.URL
+	})
+	c.Visit(testServerRootURL + "redirect_chain")
+
+	if finalURL != testServerRootURL+"redirected/" {
+		t.Errorf("Invalid URL after redirects: %q", finalURL)
+	}
+}
+
+func TestHTMLElementUnmarshalProvenance(t *testing.T) {
+	e := NewTestHTMLElement(`<ul><li class="item"><b>Tea</b> <i>4.5</i></li></ul>`, ".item")
+	record := struct {
+		Name      string `selector:"b"`
+		SourceURL string `attr:"#url"`
+		RawHTML   string `attr:"#outerHTML"`
+		Snippet   string `attr:"#outerHTML" maxlen:"8"`
+		Price     string `selector:"i" attr:"#outerHTML"`
+	}{}
+	if err := e.Unmarshal(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Name != "Tea" || record.SourceURL != "http://localhost/" {
+		t.Errorf("Invalid record: %+v", record)
+	}
+	if record.RawHTML != `<li class="item"><b>Tea</b> <i>4.5</i></li>` || record.Snippet != "<li clas…" || record.Price != `<i>4.5</i>` {
+		t.Errorf("Invalid raw HTML: %q %q %q", record.RawHTML, record.Snippet, record.Price)
+	}
+}
+
+func TestResponseRedirectChain(t *testing.T) {
+	c := NewCollector()
+
+	chains := []string{}
+	c.OnResponse(func(r *Response) {
+		chain := []string{}
+		for _, u := range r.RedirectChain {
+			chain = append(chain, u.Path)
+		}
+		chains = append(chains, strings.Join(chain, " "))
+	})
+	c.Visit(testServerRootURL + "redirect_chain")
+	c.Visit(testServerRootURL + "html")
+
+	if len(chains) != 2 || chains[0] != "/redirect_chain /redirect /redirected/" || chains[1] != "" {
+		t.Errorf("Invalid redirect chains: %q", chains)
+	}
+}
+
 func TestCollectorCookies(t *testing.T) {
 	c := NewCollector()
 
@@ -260,6 +448,28 @@ func TestCollectorCookies(t *testing.T) {
 	}
 }
 
+func TestCollectorSetCookies(t *testing.T) {
+	c := NewCollector()
+
+	cookies := []*http.Cookie{{Name: "test", Value: "testv"}}
+	if err := c.SetCookies(testServerRootURL, cookies); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Visit(testServerRootURL + "check_cookie"); err != nil {
+		t.Fatalf("Failed to use injected cookies: %s", err)
+	}
+
+	if err := c.SetCookies("/relative", cookies); err == nil {
+		t.Error("Relative URL must return an error")
+	}
+	if err := c.SetCookies("https://example.com/", []*http.Cookie{{Name: "a", Value: "b", Domain: ".example.org"}}); err == nil {
+		t.Error("Mismatching cookie domain must return an error")
+	}
+	if err := c.SetCookies("https://www.example.com/", []*http.Cookie{{Name: "a", Value: "b", Domain: ".example.com"}}); err != nil {
+		t.Errorf("Matching cookie domain returned an error: %v", err)
+	}
+}
+
 func BenchmarkVisit(b *testing.B) {
 	c := NewCollector()
 	c.OnHTML("p", func(_ *HTMLElement) {})
@@ -354,3 +564,1791 @@ func TestHTMLElement(t *testing.T) {
 		t.Errorf("element href mismatch. got %s, expected %s.\n", v.Attr("href"), "http://go-colly.org")
 	}
 }
+
+func TestCollectorDeduplicateBodies(t *testing.T) {
+	c := NewCollector()
+	c.DeduplicateBodies(true)
+
+	htmlCount := 0
+	duplicateCount := 0
+
+	c.OnHTML("p", func(_ *HTMLElement) {
+		htmlCount++
+	})
+
+	c.OnDuplicateBody(func(_ *Response) {
+		duplicateCount++
+	})
+
+	c.Visit(testServerRootURL + "html")
+	c.Visit(testServerRootURL + "html?mirror=1")
+
+	if htmlCount != 2 {
+		t.Errorf("Invalid number of OnHTML calls: %d, expected 2", htmlCount)
+	}
+
+	if duplicateCount != 1 {
+		t.Errorf("Invalid number of OnDuplicateBody calls: %d, expected 1", duplicateCount)
+	}
+}
+
+func TestExpandHiddenContent(t *testing.T) {
+	in := `<noscript><img src="a.png"></noscript><template><img src="b.png"></template>`
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExpandHiddenContent(doc.Selection)
+	srcs := []string{}
+	doc.Find("img").Each(func(_ int, s *goquery.Selection) {
+		srcs = append(srcs, s.AttrOr("src", ""))
+	})
+	if len(srcs) != 2 || srcs[0] != "a.png" || srcs[1] != "b.png" {
+		t.Errorf("Invalid hidden images: %v, expected [a.png b.png]", srcs)
+	}
+}
+
+func TestExpandComments(t *testing.T) {
+	in := `<div id="list"><!-- <a href="/page/2">next</a> --><p>visible</p><div><!--<span class="price">42</span>--></div></div>`
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &HTMLElement{DOM: doc.Find("#list")}
+	if comments := e.Comments(); len(comments) != 2 || comments[0] != `<a href="/page/2">next</a>` {
+		t.Errorf("Invalid comments: %q", comments)
+	}
+	ExpandComments(doc.Selection)
+	if href := doc.Find("#list > a").AttrOr("href", ""); href != "/page/2" {
+		t.Errorf("Invalid commented-out link: %q, expected /page/2", href)
+	}
+	if price := doc.Find(".price").Text(); price != "42" {
+		t.Errorf("Invalid commented-out price: %q, expected 42", price)
+	}
+}
+
+func TestCollectorIsolateContext(t *testing.T) {
+	c := NewCollector()
+	c.IsolateContext = true
+
+	childRequests := 0
+
+	c.OnRequest(func(r *Request) {
+		if r.Depth == 1 {
+			r.Ctx.PutInheritable("category", "books")
+			r.Ctx.Put("page", "listing")
+			return
+		}
+		childRequests++
+		if r.Ctx.Get("category") != "books" {
+			t.Error("Inheritable context value is missing from child request")
+		}
+		if r.Ctx.Get("page") != "" {
+			t.Error("Non-inheritable context value leaked to child request")
+		}
+	})
+
+	c.OnHTML("a[href]", func(e *HTMLElement) {
+		e.Request.Visit(e.Attr("href"))
+	})
+
+	c.Visit(testServerRootURL + "redirected/")
+
+	if childRequests != 1 {
+		t.Errorf("Invalid number of child requests: %d, expected 1", childRequests)
+	}
+}
+
+func TestHTMLElementFormValues(t *testing.T) {
+	in := `<form>
+<input type="hidden" name="csrf" value="token">
+<input type="text" name="q" value="colly">
+<input type="checkbox" name="exact" checked>
+<input type="checkbox" name="safe" value="1">
+<input type="radio" name="sort" value="date">
+<input type="radio" name="sort" value="score" checked>
+<input type="text" name="off" value="x" disabled>
+<select name="lang"><option value="en">English</option><option value="hu" selected>Magyar</option></select>
+<textarea name="note">hello</textarea>
+<input type="submit" name="go" value="Search">
+</form>`
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &HTMLElement{
+		DOM: doc.Find("form"),
+	}
+	expected := map[string]string{
+		"csrf":  "token",
+		"q":     "colly",
+		"exact": "on",
+		"sort":  "score",
+		"lang":  "hu",
+		"note":  "hello",
+	}
+	values := e.FormValues()
+	if len(values) != len(expected) {
+		t.Errorf("Invalid form values: %v, expected %v", values, expected)
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Errorf("Invalid value for %q: %q, expected %q", k, values[k], v)
+		}
+	}
+}
+
+func TestCollectorURLRewrite(t *testing.T) {
+	c := NewCollector()
+	c.AddURLRewrite(regexp.MustCompile(`/amp/(\w+)$`), "/$1")
+	c.AddURLRewrite(regexp.MustCompile(`\?.*$`), "")
+
+	visited := []string{}
+
+	c.OnRequest(func(r *Request) {
+		visited = append(visited, r.URL.String())
+	})
+
+	c.Visit(testServerRootURL + "amp/html")
+	c.Visit(testServerRootURL + "html?utm_source=x")
+
+	if len(visited) != 1 || visited[0] != testServerRootURL+"html" {
+		t.Errorf("Invalid visited URLs: %v, expected [%shtml]", visited, testServerRootURL)
+	}
+}
+
+func TestCollectorResults(t *testing.T) {
+	c := NewCollector()
+
+	c.OnHTML("p", func(e *HTMLElement) {
+		e.Request.Ctx.AppendResult(e.Text)
+	})
+
+	results := c.Results()
+	go func() {
+		c.Visit(testServerRootURL + "html")
+		c.Wait()
+	}()
+
+	items := []interface{}{}
+	for r := range results {
+		items = append(items, r)
+	}
+	if len(items) != 2 || items[0] != "This is a test page" {
+		t.Errorf("Invalid results: %v", items)
+	}
+
+	results = c.Results()
+	sent := make(chan struct{})
+	c.OnRequest(func(r *Request) {
+		close(sent)
+	})
+	go func() {
+		c.Visit(testServerRootURL + "html?q=1")
+		c.Wait()
+	}()
+	<-sent
+	time.Sleep(50 * time.Millisecond)
+	again := make(chan (<-chan interface{}))
+	go func() {
+		again <- c.Results()
+	}()
+	select {
+	case r := <-again:
+		if r != results {
+			t.Error("Results returned another channel during the crawl")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Results blocked by a pending send")
+	}
+	for range results {
+	}
+
+	ctx := NewContext()
+	ctx.AppendResult("a")
+	ctx.Results()[0] = "b"
+	if ctx.Results()[0] != "a" {
+		t.Error("Context results modified through the returned slice")
+	}
+}
+
+func TestCollectorFollowMetaRefresh(t *testing.T) {
+	c := NewCollector()
+	c.FollowMetaRefresh(true)
+
+	visited := []string{}
+
+	c.OnRequest(func(r *Request) {
+		visited = append(visited, r.URL.Path)
+	})
+
+	c.Visit(testServerRootURL + "meta_refresh")
+
+	if len(visited) != 3 || visited[1] != "/js_redirect" || visited[2] != "/html" {
+		t.Errorf("Invalid visited paths: %v, expected [/meta_refresh /js_redirect /html]", visited)
+	}
+}
+
+func TestParseMetaRefresh(t *testing.T) {
+	for content, expected := range map[string]string{
+		"0; url=/next":                  "/next",
+		"5;URL = '/a,b?c=1,2'":          "/a,b?c=1,2",
+		"3, url=\"/quoted\" ignored":    "/quoted",
+		"1.5 /bare":                     "/bare",
+		"0;url=/x;y":                    "/x;y",
+		"10":                            "",
+		"url=/missing-delay":            "",
+		"  2 ;  uRl=  /spaces  ":        "/spaces",
+		"0; http://example.com/a,b,c/d": "http://example.com/a,b,c/d",
+	} {
+		if u := parseMetaRefresh(content); u != expected {
+			t.Errorf("Invalid URL of %q: %q, expected %q", content, u, expected)
+		}
+	}
+}
+
+func TestCollectorRequestLimit(t *testing.T) {
+	c := NewCollector()
+	c.SetRequestLimit(2)
+
+	for i := 0; i < 2; i++ {
+		if err := c.Visit(fmt.Sprintf("%s?q=%d", testServerRootURL, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Visit(testServerRootURL + "?q=2"); err != ErrRequestLimitReached {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrRequestLimitReached)
+	}
+	if err := c.Visit(testServerRootURL + "?q=0"); err != ErrAlreadyVisited {
+		t.Errorf("Invalid error of visited URL at the limit: %v, expected %v", err, ErrAlreadyVisited)
+	}
+
+	c.SetRequestLimit(4)
+	if err := c.Visit(testServerRootURL + "?q=1"); err != ErrAlreadyVisited {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrAlreadyVisited)
+	}
+	for i := 2; i < 4; i++ {
+		if err := c.Visit(fmt.Sprintf("%s?q=%d", testServerRootURL, i)); err != nil {
+			t.Errorf("Request after raising the limit failed: %v", err)
+		}
+	}
+
+	failed := NewCollector()
+	failed.SetRequestLimit(1)
+	if err := failed.Visit(testServerRootURL + "unavailable"); err == nil {
+		t.Error("Request to an unavailable page succeeded")
+	}
+	if err := failed.Visit(testServerRootURL); err != nil {
+		t.Errorf("Failed request counted against the limit: %v", err)
+	}
+	if err := failed.Visit(testServerRootURL + "html"); err != ErrRequestLimitReached {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrRequestLimitReached)
+	}
+}
+
+func TestCollectorPerHostLimit(t *testing.T) {
+	c := NewCollector()
+	c.SetPerHostLimit(1)
+
+	if err := c.Visit(testServerRootURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Visit(testServerRootURL + "html"); err != ErrHostLimitReached {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrHostLimitReached)
+	}
+	if err := c.Visit(fmt.Sprintf("http://localhost:%d/html", testServerPort)); err != nil {
+		t.Errorf("Request to another host failed: %v", err)
+	}
+}
+
+func TestCollectorPerHostAndRequestLimit(t *testing.T) {
+	c := NewCollector()
+	c.SetRequestLimit(2)
+	c.SetPerHostLimit(1)
+
+	if err := c.Visit(testServerRootURL); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.Visit(fmt.Sprintf("%shtml?q=%d", testServerRootURL, i)); err != ErrHostLimitReached {
+			t.Errorf("Invalid error: %v, expected %v", err, ErrHostLimitReached)
+		}
+	}
+	if err := c.Visit(fmt.Sprintf("http://localhost:%d/html", testServerPort)); err != nil {
+		t.Errorf("Request to another host failed: %v", err)
+	}
+	if err := c.Visit(fmt.Sprintf("http://[::1]:%d/html", testServerPort)); err != ErrRequestLimitReached {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrRequestLimitReached)
+	}
+}
+
+func TestCollectorCrawlDeadline(t *testing.T) {
+	c := NewCollector()
+	c.SetCrawlDeadline(time.Now().Add(time.Hour))
+
+	if err := c.Visit(testServerRootURL); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetCrawlDeadline(time.Now().Add(-time.Second))
+	if err := c.Visit(testServerRootURL + "html"); err != ErrCrawlDeadlineReached {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrCrawlDeadlineReached)
+	}
+}
+
+func TestCollectorHeadBeforeGet(t *testing.T) {
+	c := NewCollector()
+	c.HeadBeforeGet(func(r *Response) bool {
+		return strings.Contains(r.Headers.Get("Content-Type"), "html")
+	})
+
+	methods := []string{}
+
+	c.OnResponse(func(r *Response) {
+		methods = append(methods, r.Request.Method)
+	})
+
+	if err := c.Visit(testServerRootURL + "html"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Visit(testServerRootURL + "robots.txt"); err != ErrHeadCheckFailed {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrHeadCheckFailed)
+	}
+
+	if len(methods) != 1 {
+		t.Errorf("Invalid number of responses: %d, expected 1", len(methods))
+	}
+}
+
+func TestCollectorOnHTMLScoped(t *testing.T) {
+	c := NewCollector()
+
+	scopedCount := 0
+
+	c.OnHTMLScoped("body", "p", func(e *HTMLElement) {
+		scopedCount++
+	})
+
+	c.OnHTMLScoped("head", "p", func(e *HTMLElement) {
+		t.Error("Scoped callback called for element outside of its root")
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	if scopedCount != 2 {
+		t.Errorf("Invalid number of scoped callback calls: %d, expected 2", scopedCount)
+	}
+}
+
+func TestRequestClone(t *testing.T) {
+	c := NewCollector()
+
+	visited := []string{}
+
+	c.OnRequest(func(r *Request) {
+		visited = append(visited, r.URL.String())
+		if r.Ctx.Get("variant") == "" {
+			r.Ctx.Put("original", "yes")
+			return
+		}
+		if r.Ctx.Get("original") != "" {
+			t.Error("Fresh context of the cloned request contains values")
+		}
+		if r.Headers.Get("X-Variant") != "b" {
+			t.Error("Invalid header of the cloned request")
+		}
+	})
+
+	c.OnResponse(func(r *Response) {
+		if r.Ctx.Get("variant") != "" {
+			return
+		}
+		ctx := NewContext()
+		ctx.Put("variant", "b")
+		clone := r.Request.Clone(ctx)
+		clone.URL.RawQuery = "variant=b"
+		clone.Headers.Set("X-Variant", "b")
+		if r.Request.URL.RawQuery != "" || r.Request.Headers.Get("X-Variant") != "" {
+			t.Error("Modifying the cloned request changed the original one")
+		}
+		if err := clone.Do(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	c.Visit(testServerRootURL)
+
+	if len(visited) != 2 || visited[1] != testServerRootURL+"?variant=b" {
+		t.Errorf("Invalid visited URLs: %v", visited)
+	}
+}
+
+func TestHTMLElementChildAttrMaps(t *testing.T) {
+	in := `<ul><li data-id="1" data-name="a">A</li><li data-id="2">B</li></ul>`
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &HTMLElement{
+		DOM: doc.Selection,
+	}
+	maps := e.ChildAttrMaps("li")
+	if len(maps) != 2 {
+		t.Fatalf("Invalid number of attribute maps: %d, expected 2", len(maps))
+	}
+	if maps[0]["data-id"] != "1" || maps[0]["data-name"] != "a" || maps[1]["data-id"] != "2" || len(maps[1]) != 1 {
+		t.Errorf("Invalid attribute maps: %v", maps)
+	}
+}
+
+func TestHTMLElementEach(t *testing.T) {
+	c := NewCollector()
+
+	indexes := []int{}
+	c.OnHTML("body", func(e *HTMLElement) {
+		e.Each("p", func(i int, p *HTMLElement) {
+			if p.Request != e.Request || p.Name != "p" {
+				t.Error("Invalid element passed to Each callback")
+			}
+			indexes = append(indexes, p.Index)
+		})
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	if len(indexes) != 2 || indexes[0] != 0 || indexes[1] != 1 {
+		t.Errorf("Invalid indexes: %v, expected [0 1]", indexes)
+	}
+
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<p>a</p><p>b</p>`))
+	texts := []string{}
+	(&HTMLElement{DOM: doc.Selection}).Each("p", func(i int, p *HTMLElement) {
+		texts = append(texts, p.Text)
+	})
+	if len(texts) != 2 || texts[1] != "b" {
+		t.Errorf("Invalid texts of a bare selection: %v", texts)
+	}
+}
+
+func TestHTMLElementBaseHref(t *testing.T) {
+	c := NewCollector()
+
+	var link, resolved string
+	c.OnHTML("body", func(e *HTMLElement) {
+		link = e.Request.AbsoluteURL(e.ChildAttr("a", "href"))
+		s := struct {
+			Link string `selector:"a" attr:"href" resolve:"true"`
+		}{}
+		e.Unmarshal(&s)
+		resolved = s.Link
+	})
+	c.Visit(testServerRootURL + "base")
+
+	if expected := testServerRootURL + "docs/page"; link != expected || resolved != expected {
+		t.Errorf("Invalid links: %q %q, expected %q", link, resolved, expected)
+	}
+}
+
+func TestHTMLElementRawText(t *testing.T) {
+	c := NewCollector()
+	c.ParseComments = true
+
+	s := struct {
+		Title   string   `selector:"title" extract:"rawText"`
+		Text    string   `selector:"p"`
+		RawText []string `selector:"p" extract:"rawText"`
+		Pre     string   `selector:"pre" extract:"rawText"`
+	}{}
+	c.OnHTML("html", func(e *HTMLElement) {
+		if err := e.Unmarshal(&s); err != nil {
+			t.Error("Cannot unmarshal struct: " + err.Error())
+		}
+	})
+	c.Visit(testServerRootURL + "entities")
+
+	if s.Title != "A &amp; B" || s.Text != "\"Fish\" & 'Chips'\u00a0<b>" || s.Pre != "x &gt; y" {
+		t.Errorf("Invalid data: %+v", s)
+	}
+	if len(s.RawText) != 2 || s.RawText[0] != `"Fish" &#38; 'Chips'&nbsp;&lt;b&gt;` || s.RawText[1] != "x" {
+		t.Errorf("Invalid raw texts: %q", s.RawText)
+	}
+}
+
+func TestHTMLElementIndex(t *testing.T) {
+	c := NewCollector()
+
+	indexes := map[string]int{}
+	c.OnHTML("p.description", func(e *HTMLElement) {
+		indexes[e.Text] = e.Index
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	if len(indexes) != 2 || indexes["This is a test page"] != 0 || indexes["This is a test paragraph"] != 1 {
+		t.Errorf("Invalid indexes: %v", indexes)
+	}
+}
+
+func TestHTMLElementLabeledValues(t *testing.T) {
+	in := `<div class="specs">
+<span class="label">Weight</span><span class="value">2kg</span>
+<span class="label">Color:</span><span class="value"> red </span>
+<span class="label">Missing</span>
+</div>
+<table><tr><td><b class="label">Size</b></td><td><i class="value">XL</i></td></tr></table>`
+	e := NewTestHTMLElement(in, "body")
+	values := e.LabeledValues(".label", ".value")
+
+	if len(values) != 3 || values["Weight"] != "2kg" || values["Color"] != "red" || values["Size"] != "XL" {
+		t.Errorf("Invalid labeled values: %v", values)
+	}
+}
+
+func TestHTMLElementChildAttrSet(t *testing.T) {
+	e := NewTestHTMLElement(`<ul>
+<li data-category="books">a</li>
+<li data-category=" music ">b</li>
+<li data-category="books">c</li>
+<li data-category="">d</li>
+<li>e</li>
+</ul>`, "ul")
+	if set := strings.Join(e.ChildAttrSet("li", "data-category"), ","); set != "books,music" {
+		t.Errorf("Invalid attribute set: %q", set)
+	}
+}
+
+func TestHTMLElementAriaLabel(t *testing.T) {
+	doc := `<span id="first">Jane</span><span id="last"> Doe </span>
+<button aria-label="Close dialog">x</button>
+<input aria-labelledby="first last" aria-label="Name">
+<div>unlabeled</div>`
+	if label := NewTestHTMLElement(doc, "button").AriaLabel(); label != "Close dialog" {
+		t.Errorf("Invalid aria-label: %q", label)
+	}
+	if label := NewTestHTMLElement(doc, "input").AriaLabel(); label != "Jane Doe" {
+		t.Errorf("Invalid aria-labelledby label: %q", label)
+	}
+	if label := NewTestHTMLElement(doc, "div").AriaLabel(); label != "" {
+		t.Errorf("Invalid label of unlabeled element: %q", label)
+	}
+	if attr := NewTestHTMLElement(doc, "button").Attr("Aria-Label"); attr != "Close dialog" {
+		t.Errorf("Invalid attribute: %q", attr)
+	}
+}
+
+func TestHTMLElementPreloadLinks(t *testing.T) {
+	e := NewTestHTMLElement(`<html><head>
+<link rel="preload" href="/app.js" as="script">
+<link rel="prefetch preload" href="next.html">
+<link rel="preconnect" href="https://cdn.example.com">
+<link rel="stylesheet" href="/style.css">
+<link rel="preload" href="/app.js" as="script">
+</head><body><p>x</p></body></html>`, "p")
+	hints := e.PreloadLinks()
+
+	expected := []ResourceHint{
+		{Rel: "preload", URL: "http://localhost/app.js", As: "script"},
+		{Rel: "prefetch", URL: "http://localhost/next.html"},
+		{Rel: "preload", URL: "http://localhost/next.html"},
+		{Rel: "preconnect", URL: "https://cdn.example.com"},
+	}
+	if len(hints) != len(expected) {
+		t.Fatalf("Invalid resource hints: %v", hints)
+	}
+	for i := range hints {
+		if hints[i] != expected[i] {
+			t.Errorf("Invalid resource hint %d: %v, expected %v", i, hints[i], expected[i])
+		}
+	}
+}
+
+func TestHTMLElementParsePaginationInfo(t *testing.T) {
+	in := `<p class="a">Showing 1–20 of 453.</p><p class="b">21 - 40 / 1,234</p><p class="c">No results</p><p class="d">1 to 20 of 1.234.567</p><p class="e">1 - 20 of 1.5</p><p class="f">1 - 20 of 1,234.5</p>`
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &HTMLElement{DOM: doc.Selection}
+	if from, to, total, err := e.ParsePaginationInfo(".a"); err != nil || from != 1 || to != 20 || total != 453 {
+		t.Errorf("Invalid pagination info: %d %d %d %v", from, to, total, err)
+	}
+	if from, to, total, err := e.ParsePaginationInfo(".b"); err != nil || from != 21 || to != 40 || total != 1234 {
+		t.Errorf("Invalid pagination info: %d %d %d %v", from, to, total, err)
+	}
+	if _, _, _, err := e.ParsePaginationInfo(".c"); err != ErrNoPaginationInfo {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrNoPaginationInfo)
+	}
+	if _, _, total, err := e.ParsePaginationInfo(".d"); err != nil || total != 1234567 {
+		t.Errorf("Invalid total of dot separated thousands: %d %v", total, err)
+	}
+	for _, sel := range []string{".e", ".f"} {
+		if _, _, total, err := e.ParsePaginationInfo(sel); err == nil {
+			t.Errorf("Decimal total %s parsed as %d", sel, total)
+		}
+	}
+}
+
+func TestHTMLElementPageMeta(t *testing.T) {
+	in := `<html><head><title> Page </title>
+<meta name="description" content="About the page">
+<meta name="keywords" content="a, b,,c">
+<meta property="og:title" content="OG title"><meta property="og:image" content="/img.png">
+<meta name="twitter:card" content="summary">
+<link rel="canonical" href="/page"></head><body><p>text</p></body></html>`
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &HTMLElement{DOM: doc.Find("p")}
+	m := e.PageMeta()
+	if m.Title != "Page" || m.Description != "About the page" || len(m.Keywords) != 3 || m.Keywords[2] != "c" {
+		t.Errorf("Invalid page metadata: %+v", m)
+	}
+	if m.OpenGraph["title"] != "OG title" || m.OpenGraph["image"] != "/img.png" || m.Twitter["card"] != "summary" || m.Canonical != "/page" {
+		t.Errorf("Invalid page metadata: %+v", m)
+	}
+}
+
+func TestCollectorBearerToken(t *testing.T) {
+	c := NewCollector()
+	refreshed := 0
+	c.SetBearerToken(testServerAddr, "expired", func() (string, error) {
+		refreshed++
+		return "fresh", nil
+	})
+
+	if err := c.Visit(testServerRootURL + "bearer"); err != nil {
+		t.Fatal(err)
+	}
+	c.AllowURLRevisit = true
+	if err := c.Visit(testServerRootURL + "bearer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Visit(fmt.Sprintf("http://localhost:%d/bearer", testServerPort)); err == nil {
+		t.Error("Token sent to another host")
+	}
+
+	if refreshed != 1 {
+		t.Errorf("Invalid number of token refreshes: %d, expected 1", refreshed)
+	}
+
+	concurrent := NewCollector()
+	concurrent.AllowURLRevisit = true
+	var lock sync.Mutex
+	refreshes := 0
+	concurrent.SetBearerToken(testServerAddr, "", func() (string, error) {
+		lock.Lock()
+		refreshes++
+		lock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		return "fresh", nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := concurrent.Visit(testServerRootURL + "bearer"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if refreshes != 1 {
+		t.Errorf("Invalid number of concurrent token refreshes: %d, expected 1", refreshes)
+	}
+
+	empty := NewCollector()
+	empty.SetBearerToken(testServerAddr, "", nil)
+	empty.OnResponse(func(r *Response) {
+		if h := r.Request.Headers.Get("Authorization"); h != "" {
+			t.Errorf("Authorization header sent without a token: %q", h)
+		}
+	})
+	empty.Visit(testServerRootURL)
+}
+
+func TestCollectorDigestAuth(t *testing.T) {
+	c := NewCollector()
+	c.SetDigestAuth("user", "pass")
+	c.AllowURLRevisit = true
+
+	statuses := []int{}
+	c.OnResponse(func(r *Response) {
+		statuses = append(statuses, r.StatusCode)
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := c.Visit(testServerRootURL + "digest"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.SetDigestAuth("user", "wrong")
+	if err := c.Visit(testServerRootURL + "digest"); err == nil {
+		t.Error("Invalid credentials accepted")
+	}
+
+	if len(statuses) != 2 {
+		t.Errorf("Invalid responses: %v, expected [200 200]", statuses)
+	}
+
+	c.SetDigestAuth("user", "pass")
+	if err := c.Visit(testServerRootURL + "digest"); err != nil {
+		t.Fatal(err)
+	}
+	authorization := "none"
+	c.OnResponse(func(r *Response) {
+		authorization = r.Request.Headers.Get("Authorization")
+	})
+	c.Visit(fmt.Sprintf("http://localhost:%d/html", testServerPort))
+	if authorization != "" {
+		t.Errorf("Digest challenge answered for another host: %q", authorization)
+	}
+}
+
+func TestCollectorDigestAuthCacheDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly-auth-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 2; i++ {
+		c := NewCollector()
+		c.CacheDir = dir
+		c.SetDigestAuth("user", "pass")
+		status := 0
+		c.OnResponse(func(r *Response) {
+			status = r.StatusCode
+		})
+		if err := c.Visit(testServerRootURL + "digest"); err != nil {
+			t.Fatal(err)
+		}
+		if status != 200 {
+			t.Errorf("Invalid status: %d, expected 200", status)
+		}
+	}
+}
+
+func TestCollectorOnRequestError(t *testing.T) {
+	c := NewCollector()
+
+	requestErrors := 0
+	httpErrors := 0
+
+	c.OnRequestError(func(r *Response, err error) {
+		requestErrors++
+	})
+
+	c.OnError(func(r *Response, err error) {
+		httpErrors++
+	})
+
+	c.Visit("http://127.0.0.1:1/")
+	c.Visit(testServerRootURL + "bearer")
+
+	if requestErrors != 1 || httpErrors != 1 {
+		t.Errorf("Invalid number of errors: %d transport and %d HTTP, expected 1 and 1", requestErrors, httpErrors)
+	}
+}
+
+func TestCollectorHostStats(t *testing.T) {
+	c := NewCollector()
+
+	if c.HostStats(testServerAddr) != nil {
+		t.Error("HostStats returned statistics without requests")
+	}
+
+	c.Visit(testServerRootURL)
+	c.Visit(testServerRootURL + "html")
+
+	stats := c.HostStats(testServerAddr)
+	if stats == nil || stats.Requests != 2 {
+		t.Fatalf("Invalid host statistics: %+v", stats)
+	}
+	if stats.P50 > stats.P90 || stats.P90 > stats.P99 || stats.P99 > stats.Max {
+		t.Errorf("Invalid percentiles: %+v", stats)
+	}
+
+	delayed := NewCollector()
+	delayed.Limit(&LimitRule{DomainGlob: "*", Delay: 200 * time.Millisecond})
+	delayed.Visit(testServerRootURL)
+	if stats := delayed.HostStats(testServerAddr); stats == nil || stats.Max >= 200*time.Millisecond {
+		t.Errorf("Host statistics include the delay of the LimitRule: %+v", stats)
+	}
+}
+
+func TestCollectorSetBandwidthLimit(t *testing.T) {
+	c := NewCollector()
+	c.SetBandwidthLimit(250)
+
+	var body []byte
+	var receive time.Duration
+	c.OnResponse(func(r *Response) {
+		body = r.Body
+		receive = r.timings.receive
+	})
+	start := time.Now()
+	if err := c.Visit(testServerRootURL + "html"); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Contains(body, []byte("</html>")) {
+		t.Fatal("Incomplete response body")
+	}
+	// the body of about 190 bytes takes about 760ms at 250 bytes/s
+	if elapsed < 500*time.Millisecond {
+		t.Errorf("Response of %d bytes read in %v, expected at least 500ms", len(body), elapsed)
+	}
+	if stats := c.HostStats(testServerAddr); stats == nil || stats.Max >= 500*time.Millisecond || receive >= 500*time.Millisecond {
+		t.Errorf("Response time includes the bandwidth limit: %+v, receive %v", stats, receive)
+	}
+}
+
+func TestCollectorProfileReport(t *testing.T) {
+	c := NewCollector()
+	c.EnableProfiling(true)
+
+	type paragraph struct {
+		Text string `selector:"*"`
+	}
+	c.OnHTML("p", func(e *HTMLElement) {
+		e.Unmarshal(&paragraph{})
+	})
+	c.OnHTML("title", func(e *HTMLElement) {})
+
+	c.Visit(testServerRootURL + "html")
+
+	report := c.ProfileReport()
+	calls := map[string]int{}
+	for i, e := range report {
+		calls[e.Name] = e.Calls
+		if i > 0 && e.Total > report[i-1].Total {
+			t.Error("Profile report is not sorted by total time")
+		}
+	}
+	if len(report) != 3 || calls["p"] != 1 || calls["title"] != 1 || calls["unmarshal *colly.paragraph"] != 2 {
+		t.Errorf("Invalid profile report: %+v", report)
+	}
+}
+
+func TestCollectorWithTransportKeepsProxy(t *testing.T) {
+	c := NewCollector()
+
+	proxied := 0
+	c.SetProxyFunc(func(r *http.Request) (*url.URL, error) {
+		proxied++
+		return nil, nil
+	})
+	c.WithTransport(&http.Transport{MaxIdleConnsPerHost: 4})
+
+	c.Visit(testServerRootURL)
+
+	if proxied != 1 {
+		t.Errorf("Proxy function was called %d times, expected 1", proxied)
+	}
+}
+
+func TestResponseRawBody(t *testing.T) {
+	c := NewCollector()
+
+	c.OnResponse(func(r *Response) {
+		switch r.Request.URL.Path {
+		case "/latin1":
+			if string(r.Body) != "café" || string(r.RawBody) != "caf\xe9" {
+				t.Errorf("Invalid bodies: %q and raw %q", r.Body, r.RawBody)
+			}
+		default:
+			if &r.RawBody[0] != &r.Body[0] {
+				t.Error("RawBody does not share the untranscoded body")
+			}
+		}
+	})
+
+	c.Visit(testServerRootURL + "latin1")
+	c.Visit(testServerRootURL)
+}
+
+func TestCollectorOnHTMLWithPriority(t *testing.T) {
+	c := NewCollector()
+
+	calls := []string{}
+	c.OnHTML("h1", func(e *HTMLElement) {
+		calls = append(calls, "first")
+	})
+	c.OnHTMLWithPriority("title", -1, func(e *HTMLElement) {
+		calls = append(calls, "low")
+	})
+	c.OnHTML("p", func(e *HTMLElement) {
+		calls = append(calls, "second")
+	})
+	c.OnHTMLWithPriority("body", 1, func(e *HTMLElement) {
+		calls = append(calls, "high")
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	expected := "high first second second low"
+	if strings.Join(calls, " ") != expected {
+		t.Errorf("Invalid callback order: %v, expected %s", calls, expected)
+	}
+}
+
+func TestCollectorDNSCache(t *testing.T) {
+	c := NewCollector()
+	c.EnableDNSCache(time.Minute)
+
+	c.Visit(fmt.Sprintf("http://localhost:%d/", testServerPort))
+	c.Visit(fmt.Sprintf("http://localhost:%d/html", testServerPort))
+
+	if len(c.dnsCache.entries) != 1 || c.dnsCache.entries["localhost"] == nil {
+		t.Errorf("Invalid DNS cache entries: %v", c.dnsCache.entries)
+	}
+
+	c.ClearDNSCache()
+	if len(c.dnsCache.entries) != 0 {
+		t.Error("ClearDNSCache did not remove the entries")
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	completed := make(chan map[string]interface{}, 1)
+	a := NewAggregator(20*time.Millisecond, func(id string, parts map[string]interface{}) {
+		completed <- parts
+	})
+	timedOut := make(chan string, 1)
+	a.OnTimeout(func(id string, parts map[string]interface{}) {
+		timedOut <- id
+	})
+
+	a.Add("1", "detail", "description")
+	a.Expect("1", "listing", "detail")
+	a.Expect("2", "listing", "detail")
+	a.Add("2", "listing", "Coffee")
+	a.Add("1", "listing", "Tea")
+
+	select {
+	case parts := <-completed:
+		if parts["listing"] != "Tea" || parts["detail"] != "description" {
+			t.Errorf("Invalid parts: %v", parts)
+		}
+	default:
+		t.Fatal("Complete record not reported")
+	}
+	select {
+	case id := <-timedOut:
+		if id != "2" {
+			t.Errorf("Invalid timed out record: %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Incomplete record did not time out")
+	}
+
+	a.Add("1", "detail", "late")
+	select {
+	case id := <-timedOut:
+		if id != "1" {
+			t.Errorf("Invalid timed out record: %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Late part did not time out")
+	}
+	if a.Pending() != 0 {
+		t.Errorf("Invalid number of pending records: %d", a.Pending())
+	}
+}
+
+func TestContextPutAny(t *testing.T) {
+	type product struct {
+		Name  string
+		Price float64
+	}
+	c := NewCollector()
+
+	var got *product
+	c.OnHTML("title", func(e *HTMLElement) {
+		e.Request.Ctx.PutAny("product", &product{e.Text, 1.5})
+	})
+	c.OnHTML("body", func(e *HTMLElement) {
+		got, _ = e.Request.Ctx.GetAny("product").(*product)
+		if v := e.Request.Ctx.Get("product"); v != "" {
+			t.Errorf("Get returned %q for a non-string value", v)
+		}
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	if got == nil || got.Name != "Test Page" || got.Price != 1.5 {
+		t.Errorf("Invalid context value: %+v", got)
+	}
+}
+
+func TestCollectorOnQueueEmpty(t *testing.T) {
+	c := NewCollector()
+
+	visited := []string{}
+	c.OnRequest(func(r *Request) {
+		visited = append(visited, r.URL.Path)
+	})
+	c.OnHTML("a[href]", func(e *HTMLElement) {
+		e.Request.Visit(e.Attr("href"))
+	})
+	seeds := []string{testServerRootURL + "html"}
+	calls := 0
+	c.OnQueueEmpty(func() {
+		calls++
+		if len(seeds) > 0 {
+			u := seeds[0]
+			seeds = seeds[1:]
+			c.Visit(u)
+		}
+	})
+
+	c.Visit(testServerRootURL)
+
+	if calls != 2 {
+		t.Errorf("Invalid number of OnQueueEmpty calls: %d", calls)
+	}
+	if len(visited) < 2 || visited[0] != "/" || visited[1] != "/html" {
+		t.Errorf("Invalid visits: %v", visited)
+	}
+
+	revisit := NewCollector()
+	revisitCalls := 0
+	revisit.OnQueueEmpty(func() {
+		revisitCalls++
+		if revisitCalls < 10 {
+			revisit.Visit(testServerRootURL)
+		}
+	})
+	revisit.Visit(testServerRootURL)
+	if err := revisit.Visit(testServerRootURL); err != ErrAlreadyVisited {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrAlreadyVisited)
+	}
+	if revisitCalls != 1 {
+		t.Errorf("Invalid number of OnQueueEmpty calls with rejected visits: %d, expected 1", revisitCalls)
+	}
+}
+
+func TestCollectorVisitAll(t *testing.T) {
+	c := NewCollector()
+	visits := 0
+	c.OnResponse(func(r *Response) {
+		visits++
+	})
+
+	errs := c.VisitAll([]string{testServerRootURL, testServerRootURL + "html", testServerRootURL})
+	if len(errs) != 3 {
+		t.Fatalf("Invalid number of errors: %d", len(errs))
+	}
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if errs[2] != ErrAlreadyVisited {
+		t.Errorf("Invalid error for duplicate URL: %v, expected %v", errs[2], ErrAlreadyVisited)
+	}
+	if visits != 2 {
+		t.Errorf("Invalid number of visits: %d", visits)
+	}
+}
+
+func TestCollectorVisitWithContext(t *testing.T) {
+	c := NewCollector()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.VisitWithContext(ctx, testServerRootURL); err != context.Canceled {
+		t.Errorf("Invalid error: %v, expected %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.VisitWithContext(ctx, testServerRootURL+"slow"); err == nil {
+		t.Error("Request was not aborted by the context")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Request was aborted too late")
+	}
+}
+
+func TestCollectorAdaptiveRateLimit(t *testing.T) {
+	c := NewCollector()
+	c.EnableAdaptiveRateLimit(AdaptiveRateLimit{
+		Step:    10 * time.Millisecond,
+		Backoff: 3,
+	})
+
+	c.Visit(testServerRootURL + "unavailable")
+	start := time.Now()
+	c.Visit(testServerRootURL + "unavailable?q=1")
+	if d := c.adaptive.delay(testServerAddr); d != 30*time.Millisecond {
+		t.Errorf("Invalid delay after overloaded responses: %v, expected 30ms", d)
+	}
+
+	c.Visit(testServerRootURL)
+	if time.Since(start) < 30*time.Millisecond {
+		t.Error("Request was not delayed")
+	}
+	if d := c.adaptive.delay(testServerAddr); d != 20*time.Millisecond {
+		t.Errorf("Invalid delay after a healthy response: %v, expected 20ms", d)
+	}
+
+	c.adaptive.update("unseen.example.com", http.StatusServiceUnavailable, 0)
+	if d := c.adaptive.delay("unseen.example.com"); d != 10*time.Millisecond {
+		t.Errorf("Invalid delay of a host without requests: %v, expected 10ms", d)
+	}
+}
+
+func TestCollectorOnHTMLOnce(t *testing.T) {
+	c := NewCollector()
+
+	texts := []string{}
+	c.OnHTMLOnce("p", func(e *HTMLElement) {
+		texts = append(texts, e.Text)
+	})
+
+	c.Visit(testServerRootURL + "html")
+	c.Visit(testServerRootURL + "html?q=1")
+
+	if len(texts) != 2 || texts[0] != texts[1] {
+		t.Errorf("Invalid OnHTMLOnce calls: %v, expected the first paragraph of both pages", texts)
+	}
+}
+
+func TestCollectorSetCheckHead(t *testing.T) {
+	c := NewCollector()
+	c.SetCheckHead(true)
+
+	c.OnHTML("a", func(e *HTMLElement) {
+		t.Error("OnHTML called in link checking mode")
+	})
+	statuses := map[string]int{}
+	c.OnLinkChecked(func(r *Response) {
+		statuses[r.Request.URL.Path] = r.StatusCode
+		if len(r.Body) != 0 && r.Request.URL.Path != "/no_head" {
+			t.Error("Body downloaded in link checking mode")
+		}
+	})
+	c.Visit(testServerRootURL + "html")
+	c.Visit(testServerRootURL + "unavailable")
+	c.Visit(testServerRootURL + "no_head")
+
+	if statuses["/html"] != 200 || statuses["/unavailable"] != 503 || statuses["/no_head"] != 200 {
+		t.Errorf("Invalid link statuses: %v", statuses)
+	}
+	if stats := c.HostStats("127.0.0.1:31337"); stats == nil || stats.Requests != 4 {
+		t.Errorf("Invalid host stats of link checks: %+v, expected 4 requests", stats)
+	}
+}
+
+func TestCollectorPauseResume(t *testing.T) {
+	c := NewCollector()
+	c.Pause()
+	c.Pause()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Visit(testServerRootURL)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Request was made while the collector was paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Resume()
+	c.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Request was not made after Resume")
+	}
+}
+
+func TestCollectorOnResponseContentType(t *testing.T) {
+	c := NewCollector()
+
+	html, json := 0, 0
+	c.OnResponseContentType("text/html", func(r *Response) {
+		html++
+	})
+	c.OnResponseContentType("application/json", func(r *Response) {
+		json++
+	})
+
+	c.Visit(testServerRootURL + "html")
+	c.Visit(testServerRootURL)
+
+	if html != 1 || json != 0 {
+		t.Errorf("Invalid number of calls: %d HTML and %d JSON, expected 1 and 0", html, json)
+	}
+}
+
+func TestCollectorDeduplicateByCanonical(t *testing.T) {
+	c := NewCollector()
+	c.DeduplicateByCanonical(true)
+
+	pages, duplicates := 0, 0
+	c.OnHTML("body", func(e *HTMLElement) {
+		pages++
+	})
+	c.OnDuplicateBody(func(r *Response) {
+		duplicates++
+	})
+
+	c.Visit(testServerRootURL + "canonical")
+	c.Visit(testServerRootURL + "canonical?q=1")
+
+	if pages != 1 || duplicates != 1 {
+		t.Errorf("Invalid number of pages: %d and duplicates: %d, expected 1 and 1", pages, duplicates)
+	}
+	if err := c.Visit(testServerRootURL + "html"); err != ErrAlreadyVisited {
+		t.Errorf("Invalid error for canonical URL: %v, expected %v", err, ErrAlreadyVisited)
+	}
+}
+
+type testCache struct {
+	responses map[string]*CachedResponse
+	sets      int
+}
+
+func (c *testCache) Get(key string) (*CachedResponse, bool) {
+	r, ok := c.responses[key]
+	return r, ok
+}
+
+func (c *testCache) Set(key string, resp *CachedResponse) error {
+	c.sets++
+	c.responses[key] = resp
+	return nil
+}
+
+func TestCollectorConditionalCache(t *testing.T) {
+	cache := &testCache{responses: make(map[string]*CachedResponse)}
+	c := NewCollector()
+	c.AllowURLRevisit = true
+	c.SetCache(cache)
+
+	bodies := []string{}
+	c.OnResponse(func(r *Response) {
+		if r.StatusCode != 200 {
+			t.Errorf("Invalid status code: %d", r.StatusCode)
+		}
+		if r.Request.Headers.Get("If-None-Match") != "" {
+			t.Error("Conditional header added to the request headers")
+		}
+		bodies = append(bodies, string(r.Body))
+	})
+
+	c.Visit(testServerRootURL + "etag")
+	c.Visit(testServerRootURL + "etag")
+
+	if len(bodies) != 2 || bodies[0] != "cached" || bodies[1] != "cached" {
+		t.Errorf("Invalid response bodies: %v", bodies)
+	}
+	if cache.sets != 2 {
+		t.Errorf("Invalid number of stored responses: %d, expected 2", cache.sets)
+	}
+	cached := cache.responses[testServerRootURL+"etag"]
+	if cached.Headers.Get("Cache-Control") != "max-age=60" || cached.Headers.Get("ETag") != `"v1"` || string(cached.Body) != "cached" {
+		t.Errorf("Cached response not refreshed by 304: %+v", cached)
+	}
+}
+
+func TestBloomStorage(t *testing.T) {
+	s := NewBloomStorage(1000, 0.01)
+	falsePositives := 0
+	for i := uint64(0); i < 1000; i++ {
+		if s.Visited(i * 0x9e3779b97f4a7c15) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("Too many false positives: %d", falsePositives)
+	}
+	for i := uint64(0); i < 1000; i++ {
+		if !s.Visited(i * 0x9e3779b97f4a7c15) {
+			t.Fatalf("Stored hash %d reported as not visited", i)
+		}
+	}
+
+	c := NewCollector()
+	c.SetStorage(NewBloomStorage(100, 0.001))
+	if err := c.Visit(testServerRootURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Visit(testServerRootURL); err != ErrAlreadyVisited {
+		t.Errorf("Invalid error for revisit: %v, expected %v", err, ErrAlreadyVisited)
+	}
+}
+
+func TestCollectorSharedStorage(t *testing.T) {
+	s := NewInMemoryStorage()
+	c1 := NewCollector()
+	c1.SetStorage(s)
+	c2 := c1.Clone()
+	c2.SetStorage(s)
+
+	visits := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			u := fmt.Sprintf("%s?q=%d", testServerRootURL, i%5)
+			visits <- c1.Visit(u)
+			visits <- c2.Visit(u)
+		}(i)
+	}
+
+	succeeded := 0
+	for i := 0; i < 20; i++ {
+		err := <-visits
+		if err == nil {
+			succeeded++
+		} else if err != ErrAlreadyVisited {
+			t.Error(err)
+		}
+	}
+	if succeeded != 5 {
+		t.Errorf("Invalid number of visited URLs: %d, expected 5", succeeded)
+	}
+}
+
+func TestCollectorOnHTMLError(t *testing.T) {
+	c := NewCollector()
+
+	errs := []string{}
+	c.OnHTMLError(func(e *HTMLElement, err error) {
+		errs = append(errs, e.Name+": "+err.Error())
+	})
+	c.OnError(func(r *Response, err error) {
+		t.Error("Extraction error passed to OnError")
+	})
+	c.OnHTML("h1", func(e *HTMLElement) {
+		e.ReportError(errors.New("missing price"))
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	if len(errs) != 1 || errs[0] != "h1: missing price" {
+		t.Errorf("Invalid extraction errors: %v", errs)
+	}
+}
+
+func TestCollectorOnDocument(t *testing.T) {
+	c := NewCollector()
+
+	c.OnDocument(func(doc *goquery.Document, r *Response) {
+		doc.Find("p.description").First().Remove()
+	})
+	descriptions := []string{}
+	c.OnHTML("p.description", func(e *HTMLElement) {
+		descriptions = append(descriptions, e.Text)
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	if len(descriptions) != 1 || descriptions[0] != "This is a test paragraph" {
+		t.Errorf("Invalid descriptions: %v", descriptions)
+	}
+}
+
+func TestCollectorEnableHAR(t *testing.T) {
+	file, err := ioutil.TempFile("", "colly_har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	c := NewCollector()
+	c.EnableHAR(file.Name())
+	c.SetHARBodyLimit(5)
+	c.Visit(testServerRootURL + "html?q=1")
+	c.Visit(testServerRootURL)
+	c.Wait()
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	har := struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				Request struct {
+					Method      string
+					URL         string
+					QueryString []struct{ Name, Value string }
+				}
+				Response struct {
+					Status  int
+					Content struct {
+						Size    int
+						Text    string
+						Comment string
+					}
+				}
+			}
+		}
+	}{}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("Invalid HAR log: %s", data)
+	}
+	e := har.Log.Entries[0]
+	if e.Request.Method != "GET" || e.Request.URL != testServerRootURL+"html?q=1" || len(e.Request.QueryString) != 1 || e.Request.QueryString[0].Value != "1" {
+		t.Errorf("Invalid HAR request: %+v", e.Request)
+	}
+	if e.Response.Status != 200 || e.Response.Content.Text != "<!DOC" || e.Response.Content.Comment != "truncated" || e.Response.Content.Size <= 5 {
+		t.Errorf("Invalid HAR response: %+v", e.Response)
+	}
+}
+
+func TestCollectorSaveSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly_snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewCollector()
+	c.OnDocument(func(doc *goquery.Document, r *Response) {
+		doc.Find("p.description").Remove()
+	})
+	c.SaveSnapshots(dir, func(r *Response) string {
+		return path.Join("pages", path.Base(r.Request.URL.Path)+".html")
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	snapshot, err := ioutil.ReadFile(path.Join(dir, "pages", "html.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(snapshot, []byte("<title>Test Page</title>")) || bytes.Contains(snapshot, []byte(`class="description"`)) {
+		t.Errorf("Invalid snapshot: %s", snapshot)
+	}
+
+	c = NewCollector()
+	c.SaveSnapshots(dir, nil)
+	c.Visit(testServerRootURL + "html")
+	u, _ := url.Parse(testServerRootURL + "html")
+	name := snapshotFileName(&Response{Request: &Request{URL: u}})
+	if _, err := os.Stat(path.Join(dir, name)); err != nil {
+		t.Errorf("Default snapshot not saved: %v", err)
+	}
+}
+
+func TestCollectorAbort(t *testing.T) {
+	c := NewCollector()
+
+	c.OnResponse(func(r *Response) {
+		c.Abort()
+	})
+	if err := c.Visit(testServerRootURL + "html"); err != nil {
+		t.Errorf("Failed to visit page: %v", err)
+	}
+	if err := c.Visit(testServerRootURL); err != ErrAborted {
+		t.Errorf("Invalid error after abort: %v, expected %v", err, ErrAborted)
+	}
+
+	c = NewCollector()
+	c.OnError(func(r *Response, err error) {
+		t.Error("Aborted request passed to OnError")
+	})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.Abort()
+	}()
+	start := time.Now()
+	if err := c.Visit(testServerRootURL + "slow"); err != ErrAborted {
+		t.Errorf("Invalid error of aborted request: %v, expected %v", err, ErrAborted)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("In-flight request was not cancelled")
+	}
+}
+
+func TestCollectorSetProxyFunc(t *testing.T) {
+	c := NewCollector()
+
+	hosts := []string{}
+	c.SetProxyFunc(func(r *http.Request) (*url.URL, error) {
+		hosts = append(hosts, r.URL.Host)
+		return nil, nil
+	})
+	if err := c.Visit(testServerRootURL); err != nil {
+		t.Errorf("Failed to visit page: %v", err)
+	}
+
+	if len(hosts) != 1 || hosts[0] != testServerAddr {
+		t.Errorf("Invalid proxied hosts: %v", hosts)
+	}
+}
+
+func TestCollectorMerge(t *testing.T) {
+	module := NewCollector()
+	module.MaxDepth = 5
+	titles := []string{}
+	module.OnHTML("title", func(e *HTMLElement) {
+		titles = append(titles, e.Text)
+	})
+	responses := 0
+	module.OnResponse(func(r *Response) {
+		responses++
+	})
+
+	c := NewCollector()
+	c.MaxDepth = 1
+	c.OnResponse(func(r *Response) {
+		if responses != 0 {
+			t.Error("Merged callback called before the own callback")
+		}
+	})
+	c.Merge(module)
+	c.Visit(testServerRootURL + "html")
+
+	if len(titles) != 1 || titles[0] != "Test Page" || responses != 1 {
+		t.Errorf("Invalid merged callback results: %v %d", titles, responses)
+	}
+	if c.MaxDepth != 1 {
+		t.Error("Configuration overridden by Merge")
+	}
+}
+
+func TestCollectorWriteResults(t *testing.T) {
+	type paragraph struct {
+		Text  string `csv:"text"`
+		Index int
+		Skip  bool `csv:"-"`
+	}
+	c := NewCollector()
+	c.AllowURLRevisit = true
+	c.OnHTML("p", func(e *HTMLElement) {
+		e.Request.Ctx.AppendResult(paragraph{Text: e.Text, Index: e.Index})
+	})
+
+	jsonl := &bytes.Buffer{}
+	done := c.WriteResultsJSONL(jsonl)
+	c.Visit(testServerRootURL + "html")
+	c.Wait()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Text":"This is a test page","Index":0,"Skip":false}
+{"Text":"This is a test paragraph","Index":1,"Skip":false}
+`
+	if jsonl.String() != expected {
+		t.Errorf("Invalid JSONL output: %q", jsonl.String())
+	}
+
+	csvOut := &bytes.Buffer{}
+	done = c.WriteResultsCSV(csvOut)
+	c.Visit(testServerRootURL + "html")
+	c.Wait()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected = "text,Index\nThis is a test page,0\nThis is a test paragraph,1\n"
+	if csvOut.String() != expected {
+		t.Errorf("Invalid CSV output: %q", csvOut.String())
+	}
+
+	mixed := NewCollector()
+	mixed.OnHTML("p", func(e *HTMLElement) {
+		if e.Index == 0 {
+			e.Request.Ctx.AppendResult(paragraph{Text: e.Text})
+		} else {
+			e.Request.Ctx.AppendResult(struct{ Other string }{e.Text})
+		}
+	})
+	done = mixed.WriteResultsCSV(&bytes.Buffer{})
+	mixed.Visit(testServerRootURL + "html")
+	mixed.Wait()
+	if err := <-done; err == nil {
+		t.Error("Results of different types written without error")
+	}
+}
+
+func TestCollectorOnLink(t *testing.T) {
+	c := NewCollector()
+
+	c.OnLink(func(link string) (string, bool) {
+		return strings.Replace(link, "?utm_source=x", "", 1), !strings.HasSuffix(link, "/dropped")
+	})
+	visited := []string{}
+	c.OnRequest(func(r *Request) {
+		visited = append(visited, r.URL.String())
+	})
+	errs := []error{}
+	c.OnResponse(func(r *Response) {
+		if r.Request.URL.Path != "/" {
+			return
+		}
+		errs = append(errs, r.Request.Visit("/html?utm_source=x"), r.Request.Visit("/dropped"))
+	})
+
+	c.Visit(testServerRootURL)
+
+	if len(visited) != 2 || visited[1] != testServerRootURL+"html" {
+		t.Errorf("Invalid visited URLs: %v", visited)
+	}
+	if len(errs) != 2 || errs[0] != nil || errs[1] != ErrLinkDropped {
+		t.Errorf("Invalid errors: %v", errs)
+	}
+}
+
+func TestNewTestHTMLElement(t *testing.T) {
+	e := NewTestHTMLElement(`<ul><li class="item" data-id="1">First</li><li class="item">Second</li></ul>`, "li.item")
+	if e == nil {
+		t.Fatal("No element created")
+	}
+	if e.Name != "li" || e.Text != "First" || e.Attr("data-id") != "1" || e.Index != 0 {
+		t.Errorf("Invalid element: %+v", e)
+	}
+	if e.Request.AbsoluteURL("/page") != "http://localhost/page" || e.Response.Request != e.Request {
+		t.Error("Invalid synthetic request")
+	}
+	if NewTestHTMLElement(`<p>x</p>`, "li") != nil {
+		t.Error("Element created without a match")
+	}
+}
+
+func TestRequestSetBody(t *testing.T) {
+	c := NewCollector()
+
+	c.OnRequest(func(r *Request) {
+		r.SetBody(strings.NewReader("name=generated"))
+	})
+	var body string
+	c.OnResponse(func(r *Response) {
+		body = string(r.Body)
+	})
+
+	c.Post(testServerRootURL+"login", map[string]string{"name": "original"})
+
+	if body != "generated" {
+		t.Errorf("Invalid response body: %q, expected %q", body, "generated")
+	}
+}
+
+func TestResponseHTMLWarnings(t *testing.T) {
+	r := &Response{Body: []byte(`<html><body><ul><li>a<li>b</ul><p>text<br></body></html>`)}
+	if w := r.HTMLWarnings(); w != nil {
+		t.Errorf("Invalid warnings of well-formed document: %v", w)
+	}
+	r = &Response{Body: []byte(`<html><body><div><span>a</div></em><section>b</body></html>`)}
+	w := r.HTMLWarnings()
+	if strings.Join(w, ", ") != "unclosed <span>, unexpected </em>, unclosed <section>" {
+		t.Errorf("Invalid warnings: %v", w)
+	}
+	r = &Response{Body: []byte(`<html><head><title>x</title></head><body> </body></html>`)}
+	if w := r.HTMLWarnings(); len(w) != 1 || w[0] != "empty <body>" {
+		t.Errorf("Invalid warnings of empty document: %v", w)
+	}
+}
+
+func TestCollectorOnHTMLAll(t *testing.T) {
+	c := NewCollector()
+
+	counts := []int{}
+	c.OnHTMLAll("p.description", func(s *goquery.Selection, r *Response) {
+		if r.Request.URL.Path != "/html" {
+			t.Errorf("Invalid response: %s", r.Request.URL)
+		}
+		counts = append(counts, s.Length())
+	})
+	c.OnHTMLAll("table", func(s *goquery.Selection, r *Response) {
+		t.Error("Callback called without matches")
+	})
+
+	c.Visit(testServerRootURL + "html")
+
+	if len(counts) != 1 || counts[0] != 2 {
+		t.Errorf("Invalid match counts: %v, expected [2]", counts)
+	}
+}
+
+func TestCollectorUse(t *testing.T) {
+	c := NewCollector()
+
+	calls := []string{}
+	c.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			calls = append(calls, "outer")
+			return next(req)
+		}
+	})
+	c.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			calls = append(calls, "inner")
+			resp, err := next(req)
+			if err == nil {
+				resp.Header.Set("X-Middleware", "inner")
+			}
+			return resp, err
+		}
+	})
+	var header string
+	c.OnResponse(func(r *Response) {
+		header = r.Headers.Get("X-Middleware")
+	})
+
+	c.Visit(testServerRootURL)
+
+	if strings.Join(calls, " ") != "outer inner" || header != "inner" {
+		t.Errorf("Invalid middleware calls %v or response header %q", calls, header)
+	}
+}
+
+func TestCollectorDeterministic(t *testing.T) {
+	c := NewCollector()
+	c.Deterministic()
+
+	visited := []string{}
+	c.OnRequest(func(r *Request) {
+		visited = append(visited, r.URL.Path)
+	})
+	c.OnHTML("a[href]", func(e *HTMLElement) {
+		if err := e.Request.Visit(e.Attr("href")); err != nil {
+			t.Errorf("Invalid error of deferred visit: %v", err)
+		}
+		if e.Index == 0 {
+			c.Visit(testServerRootURL + "redirected/")
+		}
+	})
+
+	if err := c.Visit(testServerRootURL + "links"); err != nil {
+		t.Fatal(err)
+	}
+	c.Wait()
+
+	if strings.Join(visited, " ") != "/links / /canonical /html /redirected/ /redirected/test" {
+		t.Errorf("Invalid order of requests: %v", visited)
+	}
+
+	paused := NewCollector()
+	paused.Deterministic()
+	emptyCalls := 0
+	paused.OnQueueEmpty(func() {
+		emptyCalls++
+	})
+	paused.Pause()
+	time.AfterFunc(50*time.Millisecond, paused.Abort)
+	if err := paused.Visit(testServerRootURL); err != ErrAborted {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrAborted)
+	}
+	if emptyCalls != 1 {
+		t.Errorf("Invalid number of OnQueueEmpty calls of a run aborted while paused: %d, expected 1", emptyCalls)
+	}
+}
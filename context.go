@@ -1,20 +1,26 @@
 package colly
 
 import (
+	"context"
 	"sync"
 )
 
 // Context provides a tiny layer for passing data between callbacks
 type Context struct {
-	contextMap map[string]interface{}
-	lock       *sync.RWMutex
+	contextMap  map[string]interface{}
+	inheritable map[string]bool
+	results     []interface{}
+	resultSink  func(interface{}) bool
+	reqContext  context.Context
+	lock        *sync.RWMutex
 }
 
 // NewContext initializes a new Context instance
 func NewContext() *Context {
 	return &Context{
-		contextMap: make(map[string]interface{}),
-		lock:       &sync.RWMutex{},
+		contextMap:  make(map[string]interface{}),
+		inheritable: make(map[string]bool),
+		lock:        &sync.RWMutex{},
 	}
 }
 
@@ -37,13 +43,59 @@ func (c *Context) Put(key string, value interface{}) {
 	c.lock.Unlock()
 }
 
+// PutAny stores a value of any type in Context like Put, e.g. a parsed
+// struct shared by the callbacks of a request. Use GetAny to retrieve it.
+func (c *Context) PutAny(key string, value interface{}) {
+	c.Put(key, value)
+}
+
+// PutInheritable stores a value of any type in Context and marks it
+// inheritable. Inheritable values are copied to the Context of the
+// child requests even if the Collector's IsolateContext is enabled.
+func (c *Context) PutInheritable(key string, value interface{}) {
+	c.lock.Lock()
+	c.contextMap[key] = value
+	c.inheritable[key] = true
+	c.lock.Unlock()
+}
+
+// Inherit creates a new Context which contains only the
+// inheritable values of c
+func (c *Context) Inherit() *Context {
+	n := NewContext()
+	c.lock.RLock()
+	for k := range c.inheritable {
+		n.contextMap[k] = c.contextMap[k]
+		n.inheritable[k] = true
+	}
+	n.reqContext = c.reqContext
+	c.lock.RUnlock()
+	return n
+}
+
+// Clone creates a new Context containing the values of c
+func (c *Context) Clone() *Context {
+	n := NewContext()
+	c.lock.RLock()
+	for k, v := range c.contextMap {
+		n.contextMap[k] = v
+	}
+	for k := range c.inheritable {
+		n.inheritable[k] = true
+	}
+	n.reqContext = c.reqContext
+	c.lock.RUnlock()
+	return n
+}
+
 // Get retrieves a string value from Context.
-// Get returns an empty string if key not found
+// Get returns an empty string if key not found or if the value is not
+// a string
 func (c *Context) Get(key string) string {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	if v, ok := c.contextMap[key]; ok {
-		return v.(string)
+	if v, ok := c.contextMap[key].(string); ok {
+		return v
 	}
 	return ""
 }
@@ -58,3 +110,32 @@ func (c *Context) GetAny(key string) interface{} {
 	}
 	return nil
 }
+
+// AppendResult adds an extracted item to the results of the Context.
+// If the Context belongs to a request of a Collector whose Results channel
+// is in use, the item is sent to that channel instead of being stored.
+// Sending blocks until the item is received from the channel.
+func (c *Context) AppendResult(v interface{}) {
+	c.lock.RLock()
+	sink := c.resultSink
+	c.lock.RUnlock()
+	if sink != nil && sink(v) {
+		return
+	}
+	c.lock.Lock()
+	c.results = append(c.results, v)
+	c.lock.Unlock()
+}
+
+// Results returns a copy of the items stored by AppendResult
+func (c *Context) Results() []interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]interface{}(nil), c.results...)
+}
+
+func (c *Context) setResultSink(f func(interface{}) bool) {
+	c.lock.Lock()
+	c.resultSink = f
+	c.lock.Unlock()
+}
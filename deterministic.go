@@ -0,0 +1,88 @@
+package colly
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+type deferredVisit struct {
+	url   string
+	depth int
+	ctx   *Context
+}
+
+type visitsByURL []*deferredVisit
+
+func (v visitsByURL) Len() int           { return len(v) }
+func (v visitsByURL) Less(i, j int) bool { return v[i].url < v[j].url }
+func (v visitsByURL) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// Deterministic makes the order of the requests independent of goroutine
+// scheduling, e.g. for golden tests of link following logic. It disables
+// concurrency: requests are made one at a time. The URLs visited during
+// the callbacks of a response by Request.Visit or Visit are not requested
+// immediately. They are requested after the response is processed, sorted
+// by URL, in breadth-first order, so these Visit calls return nil instead
+// of the error of the request. Visit calls of other goroutines during the
+// crawl are deferred the same way and Wait returns after them.
+func (c *Collector) Deterministic() {
+	c.lock.Lock()
+	c.deterministic = true
+	c.lock.Unlock()
+}
+
+// deferVisit stores a visit made during a deterministic crawl. It returns
+// false if no crawl is running.
+func (c *Collector) deferVisit(u string, depth int, ctx *Context) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.deterministicRunning {
+		return false
+	}
+	c.deferredVisits = append(c.deferredVisits, &deferredVisit{u, depth, ctx})
+	return true
+}
+
+// visitDeterministic requests u and the URLs visited during the crawl
+// in deterministic order. u is deferred if a crawl is running.
+func (c *Collector) visitDeterministic(u string) error {
+	c.lock.Lock()
+	if c.deterministicRunning {
+		c.deferredVisits = append(c.deferredVisits, &deferredVisit{u, 1, nil})
+		c.lock.Unlock()
+		return nil
+	}
+	c.deterministicRunning = true
+	c.lock.Unlock()
+	c.wg.Add(1)
+	defer c.wg.Done()
+	// the run is in flight until its deferred visits are made, but it
+	// only notifies OnQueueEmpty if one of them passed the checks
+	passed := false
+	atomic.AddInt32(&c.inFlight, 1)
+	defer func() {
+		if !passed {
+			atomic.AddInt32(&c.inFlight, -1)
+			return
+		}
+		c.finishRequest()
+	}()
+	err := c.scrapeChecked(u, "GET", 1, nil, nil, nil, true, &passed)
+	queue := []*deferredVisit{}
+	for {
+		c.lock.Lock()
+		visits := c.deferredVisits
+		c.deferredVisits = nil
+		if len(visits) == 0 && len(queue) == 0 {
+			c.deterministicRunning = false
+			c.lock.Unlock()
+			return err
+		}
+		c.lock.Unlock()
+		sort.Stable(visitsByURL(visits))
+		queue = append(queue, visits...)
+		v := queue[0]
+		queue = queue[1:]
+		c.scrapeChecked(v.url, "GET", v.depth, nil, v.ctx, nil, true, &passed)
+	}
+}
@@ -0,0 +1,77 @@
+package colly
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsCache caches the resolved addresses of hosts for a fixed duration
+type dnsCache struct {
+	ttl     time.Duration
+	lock    *sync.Mutex
+	entries map[string]*dnsEntry
+	dialer  *net.Dialer
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		ttl:     ttl,
+		lock:    &sync.Mutex{},
+		entries: make(map[string]*dnsEntry),
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+	}
+}
+
+// lookup returns the cached addresses of host or resolves them
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	d.lock.Lock()
+	e, ok := d.entries[host]
+	d.lock.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	d.lock.Lock()
+	d.entries[host] = &dnsEntry{addrs: addrs, expires: time.Now().Add(d.ttl)}
+	d.lock.Unlock()
+	return addrs, nil
+}
+
+// dialContext connects to the first reachable resolved address of addr
+func (d *dnsCache) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		var conn net.Conn
+		conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (d *dnsCache) clear() {
+	d.lock.Lock()
+	d.entries = make(map[string]*dnsEntry)
+	d.lock.Unlock()
+}
@@ -0,0 +1,175 @@
+package extensions
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/gocolly/colly"
+)
+
+// FeedItem is an item of a RSS or Atom feed
+type FeedItem struct {
+	// Title is the title of the item
+	Title string
+	// Link is the absolute URL of the item
+	Link string
+	// PubDate is the publication date of the item as it appears in the feed
+	PubDate string
+	// Description is the summary or the content of the item
+	Description string
+}
+
+// FeedItemFunc is called for every item of a feed. The link of the item
+// is visited if FeedItemFunc returns true.
+type FeedItemFunc func(r *colly.Response, item *FeedItem) bool
+
+type rssFeed struct {
+	Items []struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		PubDate     string `xml:"pubDate"`
+		Description string `xml:"description"`
+	} `xml:"channel>item"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		Title     string     `xml:"title"`
+		Links     []atomLink `xml:"link"`
+		Published string     `xml:"published"`
+		Updated   string     `xml:"updated"`
+		Summary   string     `xml:"summary"`
+		Content   string     `xml:"content"`
+	} `xml:"entry"`
+}
+
+// feedKey is the Context key of the feed request of a Feed call
+const feedKey = "feed_request"
+
+// feedRequest is the state of a Feed call stored in the Context of its
+// request. Child requests share the Context, so only the first response
+// carrying it is parsed.
+type feedRequest struct {
+	cb     FeedItemFunc
+	parsed bool
+	err    error
+}
+
+// feedCollectors contains the collectors whose OnResponse callbacks
+// include handleFeed
+var (
+	feedCollectorsLock sync.Mutex
+	feedCollectors     = make(map[*colly.Collector]bool)
+)
+
+// Feed visits the RSS 2.0 or Atom feed at feedURL and calls cb for every
+// item of the feed. Item links are visited as child requests of the feed
+// if cb returns true, so the registered callbacks of the collector are
+// called for them. The feed is recognized by the Context of its request,
+// so it is parsed even if its URL is rewritten or redirected. An error
+// is returned if no feed could be parsed from the response.
+// Feed registers a single OnResponse callback on c, the first time it is
+// called with c.
+func Feed(c *colly.Collector, feedURL string, cb FeedItemFunc) error {
+	feedCollectorsLock.Lock()
+	if !feedCollectors[c] {
+		feedCollectors[c] = true
+		c.OnResponse(handleFeed)
+	}
+	feedCollectorsLock.Unlock()
+
+	f := &feedRequest{cb: cb}
+	ctx := colly.NewContext()
+	ctx.Put(feedKey, f)
+	if err := c.Request("GET", feedURL, nil, ctx, nil); err != nil {
+		return err
+	}
+	if f.err != nil {
+		return f.err
+	}
+	if !f.parsed {
+		return errors.New("No feed response received from " + feedURL)
+	}
+	return nil
+}
+
+// handleFeed parses the response of the feed request of a Feed call
+func handleFeed(r *colly.Response) {
+	f, ok := r.Ctx.GetAny(feedKey).(*feedRequest)
+	if !ok || f.parsed || f.err != nil {
+		return
+	}
+	items, err := parseFeed(r.Body)
+	if err != nil {
+		f.err = err
+		return
+	}
+	f.parsed = true
+	for _, item := range items {
+		item.Link = r.Request.AbsoluteURL(item.Link)
+		if f.cb(r, item) && item.Link != "" {
+			r.Request.Visit(item.Link)
+		}
+	}
+}
+
+// parseFeed returns the items of a RSS 2.0 or Atom document
+func parseFeed(body []byte) ([]*FeedItem, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return nil, err
+	}
+	items := []*FeedItem{}
+	if root.XMLName.Local == "feed" {
+		feed := &atomFeed{}
+		if err := xml.Unmarshal(body, feed); err != nil {
+			return nil, err
+		}
+		for _, e := range feed.Entries {
+			item := &FeedItem{
+				Title:       strings.TrimSpace(e.Title),
+				PubDate:     strings.TrimSpace(e.Published),
+				Description: strings.TrimSpace(e.Summary),
+			}
+			if item.PubDate == "" {
+				item.PubDate = strings.TrimSpace(e.Updated)
+			}
+			if item.Description == "" {
+				item.Description = strings.TrimSpace(e.Content)
+			}
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					item.Link = l.Href
+					break
+				}
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	if root.XMLName.Local != "rss" {
+		return nil, errors.New("Not a RSS or Atom feed: " + root.XMLName.Local)
+	}
+	feed := &rssFeed{}
+	if err := xml.Unmarshal(body, feed); err != nil {
+		return nil, err
+	}
+	for _, i := range feed.Items {
+		items = append(items, &FeedItem{
+			Title:       strings.TrimSpace(i.Title),
+			Link:        strings.TrimSpace(i.Link),
+			PubDate:     strings.TrimSpace(i.PubDate),
+			Description: strings.TrimSpace(i.Description),
+		})
+	}
+	return items, nil
+}
@@ -0,0 +1,83 @@
+package extensions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocolly/colly"
+)
+
+func TestFeed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rss", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>First</title><link>/first</link><pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate><description>first item</description></item>
+<item><title>Second</title><link>/second</link></item>
+</channel></rss>`))
+	})
+	mux.HandleFunc("/atom", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(`<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Test</title>
+<entry><title>Entry</title><link rel="self" href="/self"/><link href="/entry"/><updated>2006-01-02T15:04:05Z</updated><summary>an entry</summary></entry>
+</feed>`))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/atom", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := colly.NewCollector()
+	visited := []string{}
+	c.OnRequest(func(r *colly.Request) {
+		visited = append(visited, r.URL.Path)
+	})
+
+	items := []*FeedItem{}
+	if err := Feed(c, ts.URL+"/rss", func(r *colly.Response, item *FeedItem) bool {
+		items = append(items, item)
+		return item.Title == "First"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Link != ts.URL+"/first" || items[0].Description != "first item" || items[0].PubDate == "" {
+		t.Errorf("Invalid RSS items: %+v", items)
+	}
+	if len(visited) != 2 || visited[1] != "/first" {
+		t.Errorf("Invalid visited pages: %v", visited)
+	}
+
+	items = items[:0]
+	Feed(c, ts.URL+"/atom", func(r *colly.Response, item *FeedItem) bool {
+		items = append(items, item)
+		return false
+	})
+	if len(items) != 1 || items[0].Link != ts.URL+"/entry" || items[0].PubDate != "2006-01-02T15:04:05Z" || items[0].Description != "an entry" {
+		t.Errorf("Invalid Atom items: %+v", items)
+	}
+
+	items = items[:0]
+	c.AllowURLRevisit = true
+	if err := Feed(c, ts.URL+"/moved", func(r *colly.Response, item *FeedItem) bool {
+		items = append(items, item)
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Errorf("Invalid redirected Atom items: %+v", items)
+	}
+	if err := Feed(c, ts.URL+"/", func(r *colly.Response, item *FeedItem) bool {
+		t.Errorf("Invalid item of a HTML page: %+v", item)
+		return false
+	}); err == nil {
+		t.Error("HTML page parsed as a feed without error")
+	}
+}
@@ -0,0 +1,45 @@
+package extensions
+
+import (
+	"github.com/gocolly/colly"
+)
+
+// CursorFunc extracts the cursor of the next page from a response.
+// It returns an empty string if there are no more pages.
+type CursorFunc func(*colly.Response) string
+
+// CursorURLFunc builds the URL of the page identified by cursor
+type CursorURLFunc func(r *colly.Request, cursor string) string
+
+const (
+	// CursorKey is the Context key of the cursor of the current page
+	CursorKey = "pagination_cursor"
+	// PageKey is the Context key of the number of followed pages
+	PageKey = "pagination_page"
+)
+
+// CursorPagination follows cursor based pagination of APIs returning the
+// cursor of the next page in their responses (e.g. "next_cursor" of a
+// JSON body). For every response, the cursor is extracted by next and the
+// next page is visited at the URL returned by pageURL until the cursor is
+// empty or maxPages pages have been followed. Set maxPages to 0 to follow
+// every page.
+// The current cursor and the number of followed pages are stored in the
+// Context of the requests under CursorKey and PageKey.
+// Pages are visited as child requests, so MaxDepth of the collector
+// limits the number of followed pages too.
+func CursorPagination(c *colly.Collector, next CursorFunc, pageURL CursorURLFunc, maxPages int) {
+	c.OnResponse(func(r *colly.Response) {
+		cursor := next(r)
+		if cursor == "" {
+			return
+		}
+		page, _ := r.Ctx.GetAny(PageKey).(int)
+		if maxPages > 0 && page >= maxPages {
+			return
+		}
+		r.Ctx.PutInheritable(CursorKey, cursor)
+		r.Ctx.PutInheritable(PageKey, page+1)
+		r.Request.Visit(pageURL(r.Request, cursor))
+	})
+}
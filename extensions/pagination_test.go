@@ -0,0 +1,43 @@
+package extensions
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocolly/colly"
+)
+
+func TestCursorPagination(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next := map[string]string{"": "a", "a": "b", "b": "c", "c": ""}
+		json.NewEncoder(w).Encode(map[string]string{
+			"next_cursor": next[r.URL.Query().Get("cursor")],
+		})
+	}))
+	defer ts.Close()
+
+	c := colly.NewCollector()
+	visited := []string{}
+	c.OnRequest(func(r *colly.Request) {
+		visited = append(visited, r.URL.RawQuery)
+	})
+
+	CursorPagination(c, func(r *colly.Response) string {
+		data := struct {
+			NextCursor string `json:"next_cursor"`
+		}{}
+		json.Unmarshal(r.Body, &data)
+		return data.NextCursor
+	}, func(r *colly.Request, cursor string) string {
+		return fmt.Sprintf("%s/?cursor=%s", ts.URL, cursor)
+	}, 2)
+
+	c.Visit(ts.URL + "/")
+
+	if len(visited) != 3 || visited[2] != "cursor=b" {
+		t.Errorf("Invalid visited pages: %v, expected [ cursor=a cursor=b]", visited)
+	}
+}
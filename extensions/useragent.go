@@ -0,0 +1,94 @@
+package extensions
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/gocolly/colly"
+)
+
+// UserAgentRotation contains the strategy of RotateUserAgents
+type UserAgentRotation struct {
+	// Weights are the relative frequencies of the user agents of the pool
+	// in random rotation. Every user agent has the same weight if Weights
+	// is empty.
+	Weights []int
+	// RoundRobin selects the user agents of the pool in order instead of
+	// randomly
+	RoundRobin bool
+	// StickyHosts keeps the first user agent selected for a host for
+	// all the later requests to the host
+	StickyHosts bool
+}
+
+type userAgentRotator struct {
+	pool     []string
+	rotation UserAgentRotation
+	total    int
+	next     int
+	hosts    map[string]string
+	lock     *sync.Mutex
+}
+
+// RotateUserAgents sets the User-Agent header of every request of the
+// collector to a user agent of pool selected by rotation.
+func RotateUserAgents(c *colly.Collector, pool []string, rotation UserAgentRotation) error {
+	if len(pool) == 0 {
+		return errors.New("Empty user agent pool")
+	}
+	r := &userAgentRotator{
+		pool:     pool,
+		rotation: rotation,
+		hosts:    make(map[string]string),
+		lock:     &sync.Mutex{},
+	}
+	if len(rotation.Weights) > 0 {
+		if len(rotation.Weights) != len(pool) {
+			return errors.New("Number of user agent weights differs from the size of the pool")
+		}
+		for _, w := range rotation.Weights {
+			if w < 0 {
+				return errors.New("Negative user agent weight")
+			}
+			r.total += w
+		}
+		if r.total == 0 {
+			return errors.New("Every user agent weight is zero")
+		}
+	}
+	c.OnRequest(func(req *colly.Request) {
+		req.Headers.Set("User-Agent", r.pick(req.URL.Host))
+	})
+	return nil
+}
+
+// pick returns the user agent of the next request to host
+func (r *userAgentRotator) pick(host string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if ua, ok := r.hosts[host]; ok {
+		return ua
+	}
+	var ua string
+	switch {
+	case r.rotation.RoundRobin:
+		ua = r.pool[r.next]
+		r.next = (r.next + 1) % len(r.pool)
+	case r.total > 0:
+		n := rand.Intn(r.total)
+		for i, w := range r.rotation.Weights {
+			if n < w {
+				ua = r.pool[i]
+				break
+			}
+			n -= w
+		}
+	default:
+		ua = r.pool[rand.Intn(len(r.pool))]
+	}
+	if r.rotation.StickyHosts {
+		r.hosts[host] = ua
+	}
+	return ua
+}
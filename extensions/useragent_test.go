@@ -0,0 +1,49 @@
+package extensions
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gocolly/colly"
+)
+
+func TestRotateUserAgents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.UserAgent()))
+	}))
+	defer ts.Close()
+
+	c := colly.NewCollector()
+	agents := []string{}
+	c.OnResponse(func(r *colly.Response) {
+		agents = append(agents, string(r.Body))
+	})
+	if err := RotateUserAgents(c, []string{"a", "b"}, UserAgentRotation{RoundRobin: true}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c.Visit(fmt.Sprintf("%s/?q=%d", ts.URL, i))
+	}
+	if fmt.Sprint(agents) != "[a b a]" {
+		t.Errorf("Invalid user agents: %v, expected [a b a]", agents)
+	}
+
+	c = colly.NewCollector()
+	agents = agents[:0]
+	c.OnResponse(func(r *colly.Response) {
+		agents = append(agents, string(r.Body))
+	})
+	RotateUserAgents(c, []string{"a", "b"}, UserAgentRotation{Weights: []int{0, 1}, StickyHosts: true})
+	for i := 0; i < 3; i++ {
+		c.Visit(fmt.Sprintf("%s/?q=%d", ts.URL, i))
+	}
+	if fmt.Sprint(agents) != "[b b b]" {
+		t.Errorf("Invalid user agents: %v, expected [b b b]", agents)
+	}
+
+	if err := RotateUserAgents(c, []string{"a"}, UserAgentRotation{Weights: []int{1, 2}}); err == nil {
+		t.Error("Invalid weights were accepted")
+	}
+}
@@ -0,0 +1,223 @@
+package colly
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// harRecorder collects the HAR entries of the requests of a collector
+// and its clones
+type harRecorder struct {
+	path      string
+	bodyLimit int
+	entries   []*harEntry
+	lock      *sync.Mutex
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	HTTPVersion string    `json:"httpVersion"`
+	Cookies     []harPair `json:"cookies"`
+	Headers     []harPair `json:"headers"`
+	QueryString []harPair `json:"queryString"`
+	HeadersSize int       `json:"headersSize"`
+	BodySize    int       `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Cookies     []harPair  `json:"cookies"`
+	Headers     []harPair  `json:"headers"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int        `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// EnableHAR enables recording the requests of the collector and its
+// clones with their responses in HTTP Archive (HAR 1.2) format, e.g. to
+// inspect a crawl in browser developer tools. The archive is written to
+// path by Wait and FlushHAR. The "wait" phase of an entry is the time
+// until the response headers are received and the "receive" phase is
+// the download of the body, so the delays of the LimitRules are not
+// included. Both are zero for responses loaded from the cache. Request
+// bodies and failed requests are not recorded. See SetHARBodyLimit to truncate the recorded bodies.
+func (c *Collector) EnableHAR(path string) {
+	c.har = &harRecorder{path: path, lock: &sync.Mutex{}}
+}
+
+// SetHARBodyLimit truncates the response bodies recorded by EnableHAR to
+// n bytes. Truncated bodies are marked by a "truncated" comment. Values
+// below 1 record the whole bodies.
+func (c *Collector) SetHARBodyLimit(n int) {
+	if c.har != nil {
+		c.har.lock.Lock()
+		c.har.bodyLimit = n
+		c.har.lock.Unlock()
+	}
+}
+
+// FlushHAR writes the requests recorded since EnableHAR to its path. It
+// is called by Wait, which ignores its error.
+func (c *Collector) FlushHAR() error {
+	if c.har == nil {
+		return nil
+	}
+	return c.har.flush()
+}
+
+func (h *harRecorder) add(req *http.Request, resp *Response, start time.Time) {
+	wait, receive := 0.0, 0.0
+	if resp.timings != nil {
+		wait = float64(resp.timings.wait) / float64(time.Millisecond)
+		receive = float64(resp.timings.receive) / float64(time.Millisecond)
+	}
+	entry := &harEntry{
+		StartedDateTime: start.Format("2006-01-02T15:04:05.000Z07:00"),
+		Time:            wait + receive,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []harPair{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			Cookies:     []harPair{},
+			Headers:     harHeaders(*resp.Headers),
+			RedirectURL: resp.Headers.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(resp.Body),
+		},
+		Timings: harTimings{Send: 0, Wait: wait, Receive: receive},
+	}
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harPair{name, v})
+		}
+	}
+	sort.Stable(harPairsByName(entry.Request.QueryString))
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	entry.Response.Content = harBody(resp.Body, resp.Headers.Get("Content-Type"), h.bodyLimit)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *harRecorder) flush() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	entries := h.entries
+	if entries == nil {
+		entries = []*harEntry{}
+	}
+	file, err := os.Create(h.path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(map[string]*harLog{"log": {
+		Version: "1.2",
+		Creator: harCreator{Name: "colly", Version: "1.0"},
+		Entries: entries,
+	}})
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// harBody returns the content of a recorded body truncated to limit
+// bytes. Bodies which are not valid UTF-8 are base64 encoded.
+func harBody(body []byte, mimeType string, limit int) harContent {
+	content := harContent{Size: len(body), MimeType: mimeType}
+	if limit > 0 && len(body) > limit {
+		body = body[:limit]
+		content.Comment = "truncated"
+	}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+func harHeaders(h http.Header) []harPair {
+	pairs := []harPair{}
+	for name, values := range h {
+		for _, v := range values {
+			pairs = append(pairs, harPair{name, v})
+		}
+	}
+	sort.Stable(harPairsByName(pairs))
+	return pairs
+}
+
+func harCookies(cookies []*http.Cookie) []harPair {
+	pairs := []harPair{}
+	for _, c := range cookies {
+		pairs = append(pairs, harPair{c.Name, c.Value})
+	}
+	return pairs
+}
+
+type harPairsByName []harPair
+
+func (p harPairsByName) Len() int           { return len(p) }
+func (p harPairsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p harPairsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
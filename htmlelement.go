@@ -1,12 +1,25 @@
 package colly
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
+// PaginationInfoRegexp matches result ranges like "Showing 1-20 of 453"
+// in HTMLElement.ParsePaginationInfo. Its first three capture groups
+// must match the first and the last item of the page and the total
+// number of items. Numbers may contain either "," or "." thousands
+// separators.
+var PaginationInfoRegexp = regexp.MustCompile(`(\d[\d,.]*)\s*(?:-|–|—|to)\s*(\d[\d,.]*)\s*(?:of|/|from)\s*(\d[\d,.]*)`)
+
 // HTMLElement is the representation of a HTML tag.
 type HTMLElement struct {
 	// Name is the name of the tag
@@ -20,6 +33,40 @@ type HTMLElement struct {
 	// DOM is the goquery parsed DOM object of the page. DOM is relative
 	// to the current HTMLElement
 	DOM *goquery.Selection
+	// Index stores the position of the current element within all the
+	// elements matched by an OnHTML callback or by Each, e.g. 2 for the
+	// third match of the selector on the page
+	Index int
+}
+
+// PageMeta contains the common metadata of a HTML page
+type PageMeta struct {
+	// Title is the text of the <title> element
+	Title string
+	// Description is the content of the "description" meta tag
+	Description string
+	// Keywords are the comma separated values of the "keywords" meta tag
+	Keywords []string
+	// OpenGraph contains the Open Graph properties ("og:" meta tags)
+	// keyed by their names without the prefix, e.g. "title" or "image"
+	OpenGraph map[string]string
+	// Twitter contains the Twitter card properties ("twitter:" meta tags)
+	// keyed by their names without the prefix, e.g. "card"
+	Twitter map[string]string
+	// Canonical is the absolute URL of <link rel="canonical">
+	Canonical string
+}
+
+// ResourceHint is a resource of a page which browsers fetch or connect
+// to in advance
+type ResourceHint struct {
+	// Rel is the link relation of the hint, e.g. "preload" or "preconnect"
+	Rel string
+	// URL is the absolute URL of the resource
+	URL string
+	// As is the type of the resource given by the "as" attribute,
+	// e.g. "script", "style" or "font"
+	As string
 }
 
 // NewHTMLElementFromSelectionNode creates a HTMLElement from a goquery.Selection Node.
@@ -34,17 +81,318 @@ func NewHTMLElementFromSelectionNode(resp *Response, s *goquery.Selection, n *ht
 	}
 }
 
+// NewTestHTMLElement creates a HTMLElement of the first element of the
+// HTML document matching selector, like the HTMLElements passed to OnHTML
+// callbacks, so extraction logic can be unit tested without a server.
+// The element has a synthetic GET request to "http://localhost/" and a
+// "200 OK" response with document as body. Requests made by Visit are
+// made by a new collector with the default configuration.
+// NewTestHTMLElement returns nil if no element matches selector.
+func NewTestHTMLElement(document, selector string) *HTMLElement {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(document))
+	if err != nil {
+		return nil
+	}
+	s := doc.Find(selector).First()
+	if s.Length() == 0 {
+		return nil
+	}
+	u, _ := url.Parse("http://localhost/")
+	ctx := NewContext()
+	req := &Request{
+		URL:       u,
+		Headers:   &http.Header{},
+		Ctx:       ctx,
+		Depth:     1,
+		Method:    "GET",
+		collector: NewCollector(),
+	}
+	resp := &Response{
+		StatusCode: 200,
+		Body:       []byte(document),
+		Ctx:        ctx,
+		Request:    req,
+		Headers:    &http.Header{"Content-Type": []string{"text/html"}},
+	}
+	resp.RawBody = resp.Body
+	return NewHTMLElementFromSelectionNode(resp, s, s.Get(0))
+}
+
+// ExpandHiddenContent re-parses the raw text content of the <noscript> and
+// <template> elements of the selection as HTML, so their contents become
+// reachable by selectors.
+func ExpandHiddenContent(s *goquery.Selection) {
+	s.Find("noscript, template").Each(func(_ int, hs *goquery.Selection) {
+		n := hs.Get(0)
+		if n.FirstChild == nil || n.FirstChild != n.LastChild || n.FirstChild.Type != html.TextNode {
+			return
+		}
+		nodes, err := html.ParseFragment(strings.NewReader(n.FirstChild.Data), &html.Node{
+			Type:     html.ElementNode,
+			Data:     "body",
+			DataAtom: atom.Body,
+		})
+		if err != nil {
+			return
+		}
+		n.RemoveChild(n.FirstChild)
+		for _, c := range nodes {
+			n.AppendChild(c)
+		}
+	})
+}
+
+// ExpandComments replaces the HTML comments of the selection with their
+// content parsed as HTML, so commented-out markup becomes reachable by
+// selectors. Every node of the selection is visited and every comment
+// is parsed, so expanding large documents with many comments is costly.
+func ExpandComments(s *goquery.Selection) {
+	for _, n := range s.Nodes {
+		for _, c := range findComments(n) {
+			nodes, err := html.ParseFragment(strings.NewReader(c.Data), &html.Node{
+				Type:     html.ElementNode,
+				Data:     "body",
+				DataAtom: atom.Body,
+			})
+			if err != nil || c.Parent == nil {
+				continue
+			}
+			for _, pn := range nodes {
+				c.Parent.InsertBefore(pn, c)
+			}
+			c.Parent.RemoveChild(c)
+		}
+	}
+}
+
+// findComments returns the comment nodes below n in document order
+func findComments(n *html.Node) []*html.Node {
+	comments := []*html.Node{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.CommentNode {
+			comments = append(comments, c)
+			continue
+		}
+		comments = append(comments, findComments(c)...)
+	}
+	return comments
+}
+
+// Comments returns the stripped text content of the HTML comments
+// inside the element
+func (h *HTMLElement) Comments() []string {
+	comments := []string{}
+	for _, n := range h.DOM.Nodes {
+		for _, c := range findComments(n) {
+			comments = append(comments, strings.TrimSpace(c.Data))
+		}
+	}
+	return comments
+}
+
+// ReportError passes an extraction error of the element to the
+// OnHTMLError callbacks of the collector
+func (h *HTMLElement) ReportError(err error) {
+	if h.Request == nil || h.Request.collector == nil {
+		return
+	}
+	h.Request.collector.handleOnHTMLError(h, err)
+}
+
 // Attr returns the selected attribute of a HTMLElement or empty string
-// if no attribute found
+// if no attribute found. Attribute names are case-insensitive.
 func (h *HTMLElement) Attr(k string) string {
 	for _, a := range h.attributes {
 		if a.Key == k {
 			return a.Val
 		}
 	}
+	lower := strings.ToLower(k)
+	for _, a := range h.attributes {
+		if a.Key == lower {
+			return a.Val
+		}
+	}
 	return ""
 }
 
+// Each iterates over the elements matched by the first argument
+// and calls the callback function on every HTMLElement match.
+// The elements share the Request and Response of h, which may be nil,
+// and their Index is their position within the matched elements.
+func (h *HTMLElement) Each(goquerySelector string, callback func(int, *HTMLElement)) {
+	h.DOM.Find(goquerySelector).Each(func(i int, s *goquery.Selection) {
+		for _, n := range s.Nodes {
+			e := &HTMLElement{
+				Name:       n.Data,
+				Request:    h.Request,
+				Response:   h.Response,
+				Text:       goquery.NewDocumentFromNode(n).Text(),
+				DOM:        s,
+				Index:      i,
+				attributes: n.Attr,
+			}
+			callback(i, e)
+		}
+	})
+}
+
+// ParsePaginationInfo extracts the range of the displayed items and the
+// total number of items from the text of the matching elements using
+// PaginationInfoRegexp, e.g. "Showing 1–20 of 453" returns 1, 20 and 453.
+// ErrNoPaginationInfo is returned if the text does not match.
+func (h *HTMLElement) ParsePaginationInfo(goquerySelector string) (from, to, total int, err error) {
+	m := PaginationInfoRegexp.FindStringSubmatch(h.ChildText(goquerySelector))
+	if len(m) < 4 {
+		return 0, 0, 0, ErrNoPaginationInfo
+	}
+	nums := make([]int, 3)
+	for i := range nums {
+		if nums[i], err = parseItemCount(m[i+1]); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return nums[0], nums[1], nums[2], nil
+}
+
+// parseItemCount parses a number of items with optional "," or "."
+// thousands separators, e.g. "1,234" or "1.234". Numbers whose separators
+// don't group thousands, e.g. "1.5", return an error.
+func parseItemCount(s string) (int, error) {
+	s = strings.TrimRight(s, ",.")
+	i := strings.IndexAny(s, ",.")
+	if i < 0 {
+		return strconv.Atoi(s)
+	}
+	groups := strings.Split(s, s[i:i+1])
+	for j, g := range groups {
+		if (j == 0 && len(g) > 3) || (j > 0 && len(g) != 3) {
+			return 0, errors.New("Invalid number of items: " + s)
+		}
+	}
+	return strconv.Atoi(strings.Join(groups, ""))
+}
+
+// PageMeta returns the metadata of the page containing the element.
+// The first occurrence of every meta tag is used.
+func (h *HTMLElement) PageMeta() *PageMeta {
+	doc := h.document()
+	m := &PageMeta{
+		Title:     strings.TrimSpace(doc.Find("title").First().Text()),
+		OpenGraph: make(map[string]string),
+		Twitter:   make(map[string]string),
+	}
+	doc.Find("meta").Each(func(_ int, s *goquery.Selection) {
+		name := strings.ToLower(s.AttrOr("property", s.AttrOr("name", "")))
+		content := strings.TrimSpace(s.AttrOr("content", ""))
+		switch {
+		case name == "description" && m.Description == "":
+			m.Description = content
+		case name == "keywords" && m.Keywords == nil:
+			for _, k := range strings.Split(content, ",") {
+				if k = strings.TrimSpace(k); k != "" {
+					m.Keywords = append(m.Keywords, k)
+				}
+			}
+		case strings.HasPrefix(name, "og:"):
+			if _, ok := m.OpenGraph[name[3:]]; !ok {
+				m.OpenGraph[name[3:]] = content
+			}
+		case strings.HasPrefix(name, "twitter:"):
+			if _, ok := m.Twitter[name[8:]]; !ok {
+				m.Twitter[name[8:]] = content
+			}
+		}
+	})
+	m.Canonical = doc.Find(`link[rel="canonical"]`).AttrOr("href", "")
+	if m.Canonical != "" && h.Request != nil {
+		m.Canonical = h.Request.AbsoluteURL(m.Canonical)
+	}
+	return m
+}
+
+// resourceHintRels are the link relations returned by PreloadLinks
+var resourceHintRels = map[string]bool{
+	"preload":       true,
+	"modulepreload": true,
+	"prefetch":      true,
+	"preconnect":    true,
+	"dns-prefetch":  true,
+	"prerender":     true,
+}
+
+// PreloadLinks returns the resource hints of the page declared by
+// <link rel="preload">, "modulepreload", "prefetch", "preconnect",
+// "dns-prefetch" and "prerender" elements in document order. The URLs
+// are absolute. Links with multiple of these relations are returned
+// once for each relation, duplicates are removed.
+func (h *HTMLElement) PreloadLinks() []ResourceHint {
+	hints := []ResourceHint{}
+	seen := make(map[ResourceHint]bool)
+	h.document().Find("link[rel][href]").Each(func(_ int, s *goquery.Selection) {
+		u := strings.TrimSpace(s.AttrOr("href", ""))
+		if h.Request != nil {
+			u = h.Request.AbsoluteURL(u)
+		}
+		if u == "" {
+			return
+		}
+		for _, rel := range strings.Fields(strings.ToLower(s.AttrOr("rel", ""))) {
+			hint := ResourceHint{Rel: rel, URL: u, As: s.AttrOr("as", "")}
+			if resourceHintRels[rel] && !seen[hint] {
+				seen[hint] = true
+				hints = append(hints, hint)
+			}
+		}
+	})
+	return hints
+}
+
+// document returns the root of the document of the element
+func (h *HTMLElement) document() *goquery.Selection {
+	return documentRoot(h.DOM)
+}
+
+// documentRoot returns the root of the document of the first element of s
+func documentRoot(s *goquery.Selection) *goquery.Selection {
+	if len(s.Nodes) == 0 {
+		return s
+	}
+	n := s.Nodes[0]
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return goquery.NewDocumentFromNode(n).Selection
+}
+
+// AriaLabel returns the accessible label of the element given by its
+// "aria-labelledby" or "aria-label" attribute. The labels referenced by
+// "aria-labelledby" take precedence and their texts are joined by
+// spaces. AriaLabel returns an empty string if the element has no label.
+func (h *HTMLElement) AriaLabel() string {
+	return ariaLabel(h.DOM)
+}
+
+// ariaLabel returns the accessible label of the first element of s
+func ariaLabel(s *goquery.Selection) string {
+	if ids := strings.Fields(s.AttrOr("aria-labelledby", "")); len(ids) > 0 {
+		labeled := documentRoot(s).Find("[id]")
+		parts := []string{}
+		for _, id := range ids {
+			label := labeled.FilterFunction(func(_ int, l *goquery.Selection) bool {
+				return l.AttrOr("id", "") == id
+			})
+			if text := strings.Join(strings.Fields(label.First().Text()), " "); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, " ")
+		}
+	}
+	return strings.TrimSpace(s.AttrOr("aria-label", ""))
+}
+
 // ChildText returns the concatenated and stripped text content of the matching
 // elements.
 func (h *HTMLElement) ChildText(goquerySelector string) string {
@@ -71,3 +419,111 @@ func (h *HTMLElement) ChildAttrs(goquerySelector, attrName string) []string {
 	})
 	return res
 }
+
+// ChildAttrSet returns the distinct non-empty stripped values of the
+// attribute of the matching elements in the order of their first
+// occurrence, e.g. the categories of the items of a listing.
+func (h *HTMLElement) ChildAttrSet(goquerySelector, attrName string) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, attr := range h.ChildAttrs(goquerySelector, attrName) {
+		if attr != "" && !seen[attr] {
+			seen[attr] = true
+			res = append(res, attr)
+		}
+	}
+	return res
+}
+
+// ChildAttrMaps returns every attribute of the matching elements as
+// attribute name-value maps in document order.
+func (h *HTMLElement) ChildAttrMaps(goquerySelector string) []map[string]string {
+	res := make([]map[string]string, 0)
+	for _, n := range h.DOM.Find(goquerySelector).Nodes {
+		attrs := make(map[string]string, len(n.Attr))
+		for _, a := range n.Attr {
+			attrs[a.Key] = a.Val
+		}
+		res = append(res, attrs)
+	}
+	return res
+}
+
+// LabeledValues returns the text of the labels matching labelSel paired
+// with the text of their values matching valueSel, e.g. for spec tables
+// like <span class="label">Weight</span><span class="value">2kg</span>.
+// The value of a label is its next sibling element or the element
+// matching valueSel inside it. Labels which are the only element of
+// their parent are paired with the next sibling of the closest ancestor
+// having siblings the same way, so labels and values in adjacent table
+// cells are paired too. Trailing colons are removed from the labels.
+// Labels without a value are skipped.
+func (h *HTMLElement) LabeledValues(labelSel, valueSel string) map[string]string {
+	res := make(map[string]string)
+	h.DOM.Find(labelSel).Each(func(_ int, label *goquery.Selection) {
+		key := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(label.Text()), ":"))
+		if key == "" {
+			return
+		}
+		for cur := label; cur.Length() > 0 && !cur.IsSelection(h.DOM); cur = cur.Parent() {
+			next := cur.Next()
+			if next.Length() == 0 {
+				if cur.Siblings().Length() > 0 {
+					return
+				}
+				continue
+			}
+			if !next.Is(valueSel) {
+				next = next.Find(valueSel).First()
+			}
+			if next.Length() > 0 {
+				res[key] = strings.TrimSpace(next.Text())
+			}
+			return
+		}
+	})
+	return res
+}
+
+// FormValues returns the name-value pairs of the form fields (inputs,
+// selects and textareas, including hidden inputs) of the HTMLElement.
+// Only the checked checkboxes and radio buttons are included.
+// Disabled fields and buttons are skipped.
+func (h *HTMLElement) FormValues() map[string]string {
+	values := make(map[string]string)
+	h.DOM.Find("input, select, textarea").Each(func(_ int, s *goquery.Selection) {
+		name, ok := s.Attr("name")
+		if !ok || name == "" {
+			return
+		}
+		if _, disabled := s.Attr("disabled"); disabled {
+			return
+		}
+		switch goquery.NodeName(s) {
+		case "textarea":
+			values[name] = s.Text()
+		case "select":
+			option := s.Find("option[selected]").First()
+			if option.Length() == 0 {
+				option = s.Find("option").First()
+			}
+			if option.Length() == 0 {
+				return
+			}
+			values[name] = option.AttrOr("value", strings.TrimSpace(option.Text()))
+		default:
+			switch strings.ToLower(s.AttrOr("type", "text")) {
+			case "submit", "button", "reset", "image", "file":
+				return
+			case "checkbox", "radio":
+				if _, checked := s.Attr("checked"); !checked {
+					return
+				}
+				values[name] = s.AttrOr("value", "on")
+			default:
+				values[name] = s.AttrOr("value", "")
+			}
+		}
+	})
+	return values
+}
@@ -1,6 +1,7 @@
 package colly
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/gob"
 	"encoding/hex"
@@ -9,6 +10,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
@@ -18,10 +20,32 @@ import (
 	"github.com/gobwas/glob"
 )
 
+// middlewareKey is the request context key of the middlewares of
+// the collector making the request
+type middlewareKey struct{}
+
 type httpBackend struct {
 	LimitRules []*LimitRule
 	Client     *http.Client
 	lock       *sync.RWMutex
+	bandwidth  *bandwidthLimiter
+}
+
+// bandwidthLimiter limits the total throughput of the response bodies
+// read by a backend
+type bandwidthLimiter struct {
+	bytesPerSec int
+	lock        sync.Mutex
+	next        time.Time
+}
+
+// throttledReader reads a response body within the limit of a
+// bandwidthLimiter. waited is the time spent waiting for the limit.
+type throttledReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+	ctx     context.Context
+	waited  time.Duration
 }
 
 // LimitRule provides connection restrictions for domains.
@@ -110,40 +134,162 @@ func (h *httpBackend) GetMatchingRule(domain string) *LimitRule {
 	return nil
 }
 
-func (h *httpBackend) Cache(request *http.Request, bodySize int, cacheDir string) (*Response, error) {
-	if cacheDir == "" || request.Method != "GET" {
-		return h.Do(request, bodySize)
-	}
-	sum := sha1.Sum([]byte(request.URL.String()))
+// CachedResponse is a response stored in a Cache
+type CachedResponse struct {
+	// StatusCode is the status code of the response
+	StatusCode int
+	// Body is the body of the response
+	Body []byte
+	// Headers contains the response HTTP headers
+	Headers http.Header
+}
+
+// Cache stores the responses of GET requests. Responses are keyed by
+// the URL of their request.
+type Cache interface {
+	// Get returns the response stored for key, if any
+	Get(key string) (*CachedResponse, bool)
+	// Set stores resp for key
+	Set(key string, resp *CachedResponse) error
+}
+
+type fileCache struct {
+	dir string
+}
+
+// NewFileCache creates a Cache storing the responses as files in dir.
+// It is the Cache used by collectors with a CacheDir.
+func NewFileCache(dir string) Cache {
+	return &fileCache{dir: dir}
+}
+
+func (f *fileCache) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
 	hash := hex.EncodeToString(sum[:])
-	dir := path.Join(cacheDir, hash[:2])
-	filename := path.Join(dir, hash)
-	if file, err := os.Open(filename); err == nil {
-		resp := new(Response)
-		err := gob.NewDecoder(file).Decode(resp)
-		file.Close()
-		if resp.StatusCode < 500 {
-			return resp, err
-		}
+	return path.Join(f.dir, hash[:2], hash)
+}
+
+func (f *fileCache) Get(key string) (*CachedResponse, bool) {
+	file, err := os.Open(f.filename(key))
+	if err != nil {
+		return nil, false
 	}
-	resp, err := h.Do(request, bodySize)
-	if err != nil || resp.StatusCode >= 500 {
-		return resp, err
+	defer file.Close()
+	resp := new(CachedResponse)
+	if err := gob.NewDecoder(file).Decode(resp); err != nil {
+		return nil, false
 	}
+	return resp, true
+}
+
+func (f *fileCache) Set(key string, resp *CachedResponse) error {
+	filename := f.filename(key)
+	dir := path.Dir(filename)
 	if _, err := os.Stat(dir); err != nil {
 		if err := os.MkdirAll(dir, 0750); err != nil {
-			return resp, err
+			return err
 		}
 	}
 	file, err := os.Create(filename + "~")
 	if err != nil {
-		return resp, err
+		return err
 	}
 	defer file.Close()
 	if err := gob.NewEncoder(file).Encode(resp); err != nil {
+		return err
+	}
+	return os.Rename(filename+"~", filename)
+}
+
+// Cache returns the response of a GET request from cache if it is stored
+// there, otherwise it makes the request and stores its response.
+// Stored responses are revalidated by conditional requests if revalidate
+// is true.
+func (h *httpBackend) Cache(request *http.Request, bodySize int, cache Cache, revalidate bool) (*Response, error) {
+	if cache == nil || request.Method != "GET" {
+		return h.Do(request, bodySize)
+	}
+	key := request.URL.String()
+	cached, ok := cache.Get(key)
+	sent := request
+	if ok && cached.StatusCode < 500 {
+		if !revalidate {
+			return cached.response(), nil
+		}
+		sent = conditionalRequest(request, cached.Headers)
+	}
+	resp, err := h.Do(sent, bodySize)
+	if sent != request {
+		header := request.Header
+		*request = *sent
+		request.Header = header
+	}
+	if err != nil {
 		return resp, err
 	}
-	return resp, os.Rename(filename+"~", filename)
+	if ok && resp.StatusCode == http.StatusNotModified {
+		updated := cached.refresh(*resp.Headers)
+		revalidated := updated.response()
+		revalidated.timings = resp.timings
+		return revalidated, cache.Set(key, updated)
+	}
+	if resp.StatusCode >= 500 {
+		return resp, nil
+	}
+	return resp, cache.Set(key, &CachedResponse{
+		StatusCode: resp.StatusCode,
+		Body:       resp.Body,
+		Headers:    *resp.Headers,
+	})
+}
+
+// conditionalRequest returns a copy of request revalidating a cached
+// response by its ETag and Last-Modified headers. The headers of request
+// are not modified.
+func conditionalRequest(request *http.Request, cached http.Header) *http.Request {
+	r := request.WithContext(request.Context())
+	r.Header = make(http.Header, len(request.Header)+2)
+	for k, v := range request.Header {
+		r.Header[k] = v
+	}
+	if etag := cached.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := cached.Get("Last-Modified"); lastModified != "" && r.Header.Get("If-Modified-Since") == "" {
+		r.Header.Set("If-Modified-Since", lastModified)
+	}
+	return r
+}
+
+// refresh returns a copy of r with the headers of a "304 Not Modified"
+// response revalidating it, e.g. new Date, Expires or Cache-Control headers
+func (r *CachedResponse) refresh(notModified http.Header) *CachedResponse {
+	headers := http.Header{}
+	for k, v := range r.Headers {
+		headers[k] = v
+	}
+	for k, v := range notModified {
+		if k != "Content-Length" {
+			headers[k] = v
+		}
+	}
+	return &CachedResponse{
+		StatusCode: r.StatusCode,
+		Body:       r.Body,
+		Headers:    headers,
+	}
+}
+
+func (r *CachedResponse) response() *Response {
+	headers := r.Headers
+	if headers == nil {
+		headers = http.Header{}
+	}
+	return &Response{
+		StatusCode: r.StatusCode,
+		Body:       r.Body,
+		Headers:    &headers,
+	}
 }
 
 func (h *httpBackend) Do(request *http.Request, bodySize int) (*Response, error) {
@@ -160,13 +306,31 @@ func (h *httpBackend) Do(request *http.Request, bodySize int) (*Response, error)
 		}(r)
 	}
 
-	res, err := h.Client.Do(request)
+	start := time.Now()
+	do := RoundTripFunc(h.Client.Do)
+	if middlewares, ok := request.Context().Value(middlewareKey{}).([]Middleware); ok {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			do = middlewares[i](do)
+		}
+	}
+	res, err := do(request)
 	if err != nil {
 		return nil, err
 	}
-	*request = *res.Request
+	wait := time.Since(start)
+	if res.Request != nil {
+		*request = *res.Request
+	}
 
 	var bodyReader io.Reader = res.Body
+	var throttled *throttledReader
+	h.lock.RLock()
+	limiter := h.bandwidth
+	h.lock.RUnlock()
+	if limiter != nil {
+		throttled = &throttledReader{r: res.Body, limiter: limiter, ctx: request.Context()}
+		bodyReader = throttled
+	}
 	if bodySize > 0 {
 		bodyReader = io.LimitReader(bodyReader, int64(bodySize))
 	}
@@ -175,13 +339,36 @@ func (h *httpBackend) Do(request *http.Request, bodySize int) (*Response, error)
 	if err != nil {
 		return nil, err
 	}
+	receive := time.Since(start) - wait
+	if throttled != nil {
+		receive -= throttled.waited
+	}
 	return &Response{
-		StatusCode: res.StatusCode,
-		Body:       body,
-		Headers:    &res.Header,
+		StatusCode:    res.StatusCode,
+		Body:          body,
+		Headers:       &res.Header,
+		RedirectChain: redirectChain(res.Request),
+		timings:       &responseTimings{wait: wait, receive: receive},
 	}, nil
 }
 
+// redirectChain returns the URLs of the requests which led to req,
+// in the order they were made
+func redirectChain(req *http.Request) []*url.URL {
+	if req == nil || req.Response == nil {
+		return nil
+	}
+	chain := []*url.URL{}
+	for r := req; r != nil; {
+		chain = append([]*url.URL{r.URL}, chain...)
+		if r.Response == nil {
+			break
+		}
+		r = r.Response.Request
+	}
+	return chain
+}
+
 func (h *httpBackend) Limit(rule *LimitRule) error {
 	h.lock.Lock()
 	h.LimitRules = append(h.LimitRules, rule)
@@ -189,6 +376,61 @@ func (h *httpBackend) Limit(rule *LimitRule) error {
 	return rule.Init()
 }
 
+// SetBandwidthLimit limits the total throughput of the response bodies
+// to bytesPerSec. Values below 1 remove the limit.
+func (h *httpBackend) SetBandwidthLimit(bytesPerSec int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if bytesPerSec < 1 {
+		h.bandwidth = nil
+		return
+	}
+	h.bandwidth = &bandwidthLimiter{bytesPerSec: bytesPerSec}
+}
+
+// wait blocks until n more bytes can be read within the limit or ctx
+// is done
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.bytesPerSec))
+	delay := l.next.Sub(now)
+	l.lock.Unlock()
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Read reads at most a tenth of a second's worth of bytes at a time, so
+// concurrent readers share the bandwidth evenly
+func (t *throttledReader) Read(p []byte) (int, error) {
+	chunk := t.limiter.bytesPerSec / 10
+	if chunk < 1 {
+		chunk = 1
+	}
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		start := time.Now()
+		werr := t.limiter.wait(t.ctx, n)
+		t.waited += time.Since(start)
+		if werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
 func (h *httpBackend) Limits(rules []*LimitRule) error {
 	for _, r := range rules {
 		if err := h.Limit(r); err != nil {
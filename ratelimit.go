@@ -0,0 +1,128 @@
+package colly
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveRateLimit contains the control parameters of the adaptive
+// per host rate limiting enabled by Collector.EnableAdaptiveRateLimit.
+// The delay between the requests to a host is adjusted after every
+// response in an AIMD (additive increase, multiplicative decrease of
+// the request rate) fashion: it is multiplied by Backoff if the server
+// appears to be overloaded and decreased by Step otherwise.
+type AdaptiveRateLimit struct {
+	// MinDelay is the lowest delay between the requests to a host
+	MinDelay time.Duration
+	// MaxDelay is the highest delay between the requests to a host.
+	// Defaults to one minute.
+	MaxDelay time.Duration
+	// Step is the amount the delay is decreased by after healthy responses.
+	// The delay of an overloaded host is raised to at least Step.
+	// Defaults to 100 milliseconds.
+	Step time.Duration
+	// Backoff is the factor the delay is multiplied by after responses
+	// of an overloaded host. Defaults to 2.
+	Backoff float64
+	// SlowResponse is the response time above which a host is considered
+	// overloaded. The response time is the time until the response
+	// headers are received. Responses with 429 Too Many Requests or 503 Service
+	// Unavailable status codes always count as overloaded.
+	// Set it to 0 to ignore response times.
+	SlowResponse time.Duration
+}
+
+type adaptiveLimiter struct {
+	rule  AdaptiveRateLimit
+	lock  *sync.Mutex
+	hosts map[string]*adaptiveHost
+}
+
+type adaptiveHost struct {
+	delay time.Duration
+	last  time.Time
+}
+
+func newAdaptiveLimiter(rule AdaptiveRateLimit) *adaptiveLimiter {
+	if rule.MaxDelay == 0 {
+		rule.MaxDelay = time.Minute
+	}
+	if rule.Step == 0 {
+		rule.Step = 100 * time.Millisecond
+	}
+	if rule.Backoff <= 1 {
+		rule.Backoff = 2
+	}
+	return &adaptiveLimiter{
+		rule:  rule,
+		lock:  &sync.Mutex{},
+		hosts: make(map[string]*adaptiveHost),
+	}
+}
+
+// EnableAdaptiveRateLimit enables per host delays adapting to the
+// response times and status codes of the hosts. See AdaptiveRateLimit
+// for the control parameters. The adaptive delays are applied in
+// addition to the delays of the LimitRules.
+func (c *Collector) EnableAdaptiveRateLimit(rule AdaptiveRateLimit) {
+	c.adaptive = newAdaptiveLimiter(rule)
+}
+
+// wait blocks until the next request to host is allowed
+func (l *adaptiveLimiter) wait(host string) {
+	l.lock.Lock()
+	h := l.host(host)
+	now := time.Now()
+	start := h.last.Add(h.delay)
+	if start.Before(now) {
+		start = now
+	}
+	h.last = start
+	l.lock.Unlock()
+	time.Sleep(start.Sub(now))
+}
+
+// host returns the state of host, creating it if the host is new.
+// l.lock must be held.
+func (l *adaptiveLimiter) host(host string) *adaptiveHost {
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &adaptiveHost{delay: l.rule.MinDelay}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// update adjusts the delay of host according to a response
+func (l *adaptiveLimiter) update(host string, statusCode int, responseTime time.Duration) {
+	overloaded := statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable ||
+		(l.rule.SlowResponse > 0 && responseTime > l.rule.SlowResponse)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	h := l.host(host)
+	if overloaded {
+		h.delay = time.Duration(float64(h.delay) * l.rule.Backoff)
+		if h.delay < l.rule.Step {
+			h.delay = l.rule.Step
+		}
+		if h.delay > l.rule.MaxDelay {
+			h.delay = l.rule.MaxDelay
+		}
+		return
+	}
+	h.delay -= l.rule.Step
+	if h.delay < l.rule.MinDelay {
+		h.delay = l.rule.MinDelay
+	}
+}
+
+// delay returns the current delay of host
+func (l *adaptiveLimiter) delay(host string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if h, ok := l.hosts[host]; ok {
+		return h.delay
+	}
+	return l.rule.MinDelay
+}
@@ -0,0 +1,127 @@
+package colly
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// textEscaper encodes the text of the nodes missing from the source
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", " ", "&nbsp;")
+
+// sourceText is a text token of an HTML source
+type sourceText struct {
+	raw  string
+	data string
+}
+
+// rawText returns the text of the first element of s as it is written
+// in the source, with its HTML entities left undecoded. Text nodes
+// missing from the source, e.g. the ones added by ExpandComments, and
+// all text of documents without a source are entity-encoded by
+// textEscaper.
+func (u *unmarshaller) rawText(s *goquery.Selection) string {
+	if s.Length() == 0 {
+		return ""
+	}
+	root := s.Nodes[0]
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	if u.sourceRoot != root {
+		u.sourceRoot = root
+		u.sourceTexts = mapSourceTexts(u.body, root)
+	}
+	var buf bytes.Buffer
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			if raw, ok := u.sourceTexts[n]; ok {
+				buf.WriteString(raw)
+			} else {
+				buf.WriteString(textEscaper.Replace(n.Data))
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(s.Nodes[0])
+	return strings.TrimSpace(buf.String())
+}
+
+// mapSourceTexts maps the text nodes of the document root to their
+// source in body. The parser merges adjacent text tokens, drops
+// whitespace in some places and splits whitespace prefixes off, so the
+// text nodes are matched to runs of text tokens in document order.
+// Nodes which cannot be matched are left out of the map.
+func mapSourceTexts(body []byte, root *html.Node) map[*html.Node]string {
+	if len(body) == 0 {
+		return nil
+	}
+	var tokens []sourceText
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt == html.TextToken {
+			raw := string(z.Raw())
+			tokens = append(tokens, sourceText{raw: raw, data: z.Token().Data})
+		}
+	}
+	texts := make(map[*html.Node]string)
+	i, offset := 0, 0
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode && n.Data != "" {
+			if raw, j, o, ok := matchSourceText(tokens, i, offset, n.Data); ok {
+				texts[n] = raw
+				i, offset = j, o
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(root)
+	return texts
+}
+
+// matchSourceText returns the source of the text data starting at the
+// offset of the i-th token or after the tokens skipped before it, and
+// the position following it. Tokens are only split after a prefix
+// written without entities, so offset is valid both in the raw and in
+// the decoded text of a token.
+func matchSourceText(tokens []sourceText, i, offset int, data string) (string, int, int, bool) {
+	var raw bytes.Buffer
+	rest := data
+	for rest != "" {
+		if i >= len(tokens) {
+			return "", 0, 0, false
+		}
+		t := tokens[i]
+		tRaw, tData := t.raw[offset:], t.data[offset:]
+		switch {
+		case strings.HasPrefix(rest, tData):
+			raw.WriteString(tRaw)
+			rest = rest[len(tData):]
+			i, offset = i+1, 0
+		case strings.HasPrefix(tData, rest) && strings.HasPrefix(tRaw, rest):
+			raw.WriteString(rest)
+			offset += len(rest)
+			rest = ""
+		case rest != data:
+			return "", 0, 0, false
+		case strings.HasPrefix(tRaw, "\n") && strings.HasPrefix(tData, "\n"):
+			// leading newlines of <pre> and <textarea> are dropped
+			offset++
+		default:
+			i, offset = i+1, 0
+		}
+	}
+	return raw.String(), i, offset, true
+}
@@ -10,7 +10,8 @@ import (
 
 // Request is the representation of a HTTP request made by a Collector
 type Request struct {
-	// URL is the parsed URL of the HTTP request
+	// URL is the parsed URL of the HTTP request. It is updated to the
+	// URL of the last hop of redirects once the response is received
 	URL *url.URL
 	// Headers contains the Request's HTTP headers
 	Headers *http.Header
@@ -25,16 +26,22 @@ type Request struct {
 	// Unique identifier of the request
 	Id        uint32
 	collector *Collector
+	baseURL   *url.URL
 }
 
 // AbsoluteURL returns with the resolved absolute URL of an URL chunk.
-// AbsoluteURL returns empty string if the URL chunk is a fragment or
-// could not be parsed
+// URL chunks are resolved against the <base href> of HTML responses if
+// there is one. AbsoluteURL returns empty string if the URL chunk is a
+// fragment or could not be parsed
 func (r *Request) AbsoluteURL(u string) string {
 	if strings.HasPrefix(u, "#") {
 		return ""
 	}
-	absURL, err := r.URL.Parse(u)
+	base := r.URL
+	if r.baseURL != nil {
+		base = r.baseURL
+	}
+	absURL, err := base.Parse(u)
 	if err != nil {
 		return ""
 	}
@@ -47,23 +54,31 @@ func (r *Request) AbsoluteURL(u string) string {
 
 // Visit continues Collector's collecting job by creating a
 // request and preserves the Context of the previous request.
-// Visit also calls the previously provided callbacks
+// Visit also calls the previously provided callbacks.
+// The URL is rewritten or dropped by the OnLink callbacks first.
 func (r *Request) Visit(URL string) error {
-	return r.collector.scrape(r.AbsoluteURL(URL), "GET", r.Depth+1, nil, r.Ctx, nil, true)
+	link, ok := r.collector.handleOnLink(r.AbsoluteURL(URL))
+	if !ok {
+		return ErrLinkDropped
+	}
+	if r.collector.deterministic && r.collector.deferVisit(link, r.Depth+1, r.childContext()) {
+		return nil
+	}
+	return r.collector.scrape(link, "GET", r.Depth+1, nil, r.childContext(), nil, true)
 }
 
 // Post continues a collector job by creating a POST request and preserves the Context
 // of the previous request.
 // Post also calls the previously provided callbacks
 func (r *Request) Post(URL string, requestData map[string]string) error {
-	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, createFormReader(requestData), r.Ctx, nil, true)
+	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, createFormReader(requestData), r.childContext(), nil, true)
 }
 
 // PostRaw starts a collector job by creating a POST request with raw binary data.
 // PostRaw preserves the Context of the previous request
 // and calls the previously provided callbacks
 func (r *Request) PostRaw(URL string, requestData []byte) error {
-	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, bytes.NewReader(requestData), r.Ctx, nil, true)
+	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, bytes.NewReader(requestData), r.childContext(), nil, true)
 }
 
 // PostMultipart starts a collector job by creating a Multipart POST request
@@ -74,7 +89,56 @@ func (r *Request) PostMultipart(URL string, requestData map[string][]byte) error
 	hdr := http.Header{}
 	hdr.Set("Content-Type", "multipart/form-data; boundary="+boundary)
 	hdr.Set("User-Agent", r.collector.UserAgent)
-	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, createMultipartReader(boundary, requestData), r.Ctx, hdr, true)
+	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, createMultipartReader(boundary, requestData), r.childContext(), hdr, true)
+}
+
+// SetBody replaces the body of the request. Calling it from an OnRequest
+// callback sends body instead of the body passed to Post or PostRaw,
+// e.g. a GraphQL query depending on the state of the crawl.
+// Bodies implementing io.Seeker are sent again on authentication retries.
+func (r *Request) SetBody(body io.Reader) {
+	r.Body = body
+}
+
+// childContext returns the Context of the requests spawned from r
+func (r *Request) childContext() *Context {
+	if r.collector.IsolateContext {
+		return r.Ctx.Inherit()
+	}
+	return r.Ctx
+}
+
+// Clone creates a copy of the request with deep copied URL and headers, so
+// the copy can be modified and submitted by Do without affecting r.
+// The copy uses ctx as its Context, or a copy of r.Ctx if ctx is nil.
+// Pass NewContext() to start the copy with an empty Context.
+// The request body is shared between r and the copy.
+func (r *Request) Clone(ctx *Context) *Request {
+	if ctx == nil {
+		ctx = r.Ctx.Clone()
+	}
+	u := *r.URL
+	hdr := http.Header{}
+	if r.Headers != nil {
+		for k, v := range *r.Headers {
+			hdr[k] = append([]string(nil), v...)
+		}
+	}
+	return &Request{
+		URL:       &u,
+		Headers:   &hdr,
+		Ctx:       ctx,
+		Depth:     r.Depth,
+		Method:    r.Method,
+		Body:      r.Body,
+		collector: r.collector,
+	}
+}
+
+// Do submits the request with its current parameters. Unlike Retry,
+// Do checks whether the URL has already been visited.
+func (r *Request) Do() error {
+	return r.collector.scrape(r.URL.String(), r.Method, r.Depth, r.Body, r.Ctx, *r.Headers, true)
 }
 
 // Retry submits HTTP request again with the same parameters
@@ -6,9 +6,12 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/saintfish/chardet"
+	"golang.org/x/net/html"
 	"golang.org/x/net/html/charset"
 )
 
@@ -18,12 +21,32 @@ type Response struct {
 	StatusCode int
 	// Body is the content of the Response
 	Body []byte
+	// RawBody is the content of the Response as it was received before
+	// transcoding to UTF-8. RawBody and Body share the same slice if the
+	// body has not been transcoded.
+	RawBody []byte
 	// Ctx is a context between a Request and a Response
 	Ctx *Context
 	// Request is the Request object of the response
 	Request *Request
 	// Headers contains the Response's HTTP headers
 	Headers *http.Header
+	// RedirectChain contains the URLs the request passed through, from
+	// the requested URL to the URL of the response. It is empty if the
+	// request was not redirected.
+	RedirectChain []*url.URL
+	timings       *responseTimings
+}
+
+// responseTimings are the durations of receiving a response from the
+// server. They are nil for responses loaded from the cache.
+type responseTimings struct {
+	// wait is the time from sending the request until the response
+	// headers are received
+	wait time.Duration
+	// receive is the time spent reading the response body, without the
+	// waits of the bandwidth limit
+	receive time.Duration
 }
 
 // Save writes response body to disk
@@ -44,7 +67,96 @@ func (r *Response) FileName() string {
 	return SanitizeFileName(r.Request.URL.Path[1:])
 }
 
+// voidElements have no end tags
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "keygen": true, "link": true,
+	"meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// optionalEndElements may be closed implicitly
+var optionalEndElements = map[string]bool{
+	"html": true, "head": true, "body": true, "p": true, "li": true,
+	"dt": true, "dd": true, "option": true, "optgroup": true, "rp": true,
+	"rt": true, "thead": true, "tbody": true, "tfoot": true, "tr": true,
+	"td": true, "th": true, "caption": true, "colgroup": true,
+}
+
+// HTMLWarnings returns the problems of the HTML body of the response
+// which the lenient HTML parser corrects silently, so extraction from
+// the page can be unreliable. The warnings report unclosed tags (e.g.
+// `unclosed <div>`), end tags without start tags (`unexpected </span>`)
+// and bodies which don't parse into a non-empty <body> element
+// (`empty <body>`). Elements with optional end tags like <p> and <li>
+// are not reported as unclosed. HTMLWarnings returns nil for well-formed
+// documents.
+func (r *Response) HTMLWarnings() []string {
+	var warnings []string
+	stack := []string{}
+	z := html.NewTokenizer(bytes.NewReader(r.Body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		name, _ := z.TagName()
+		tag := string(name)
+		switch tt {
+		case html.StartTagToken:
+			if !voidElements[tag] {
+				stack = append(stack, tag)
+			}
+		case html.EndTagToken:
+			i := len(stack) - 1
+			for i >= 0 && stack[i] != tag {
+				i--
+			}
+			if i < 0 {
+				if !voidElements[tag] && !optionalEndElements[tag] {
+					warnings = append(warnings, "unexpected </"+tag+">")
+				}
+				continue
+			}
+			for _, open := range stack[i+1:] {
+				if !optionalEndElements[open] {
+					warnings = append(warnings, "unclosed <"+open+">")
+				}
+			}
+			stack = stack[:i]
+		}
+	}
+	for _, open := range stack {
+		if !optionalEndElements[open] {
+			warnings = append(warnings, "unclosed <"+open+">")
+		}
+	}
+	if doc, err := html.Parse(bytes.NewReader(r.Body)); err != nil || !hasBodyContent(doc) {
+		warnings = append(warnings, "empty <body>")
+	}
+	return warnings
+}
+
+// hasBodyContent reports whether the <body> element below n has child
+// elements or text
+func hasBodyContent(n *html.Node) bool {
+	if n.Type == html.ElementNode && n.Data == "body" {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode || (c.Type == html.TextNode && strings.TrimSpace(c.Data) != "") {
+				return true
+			}
+		}
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if hasBodyContent(c) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Response) fixCharset(detectCharset bool) {
+	r.RawBody = r.Body
 	contentType := strings.ToLower(r.Headers.Get("Content-Type"))
 	if !strings.Contains(contentType, "charset") {
 		if !detectCharset {
@@ -0,0 +1,105 @@
+package colly
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// WriteResultsJSONL writes the items streamed by Results to w as JSON
+// lines in the background until the channel is closed by Wait. Items are
+// written as they are appended, so the results are never buffered as a
+// whole. The returned channel receives the first write error or nil
+// once all the items are written:
+//
+//	done := c.WriteResultsJSONL(file)
+//	c.Visit(u)
+//	c.Wait()
+//	err := <-done
+//
+// Items are still received after a write error so the crawl is not
+// blocked.
+func (c *Collector) WriteResultsJSONL(w io.Writer) <-chan error {
+	enc := json.NewEncoder(w)
+	return c.writeResults(func(item interface{}) error {
+		return enc.Encode(item)
+	})
+}
+
+// WriteResultsCSV writes the items streamed by Results to w as CSV rows
+// like WriteResultsJSONL. Items must be structs or pointers to structs
+// of the same type. The first row contains the column names, which are
+// the "csv" tags of the exported fields or the field names, in the order
+// of the fields. Fields tagged `csv:"-"` are skipped. Items of another
+// type than the first one are reported as a write error.
+func (c *Collector) WriteResultsCSV(w io.Writer) <-chan error {
+	cw := csv.NewWriter(w)
+	var columns []int
+	var itemType reflect.Type
+	return c.writeResults(func(item interface{}) error {
+		v := reflect.Indirect(reflect.ValueOf(item))
+		if v.Kind() != reflect.Struct {
+			return errors.New("Invalid CSV result type: " + v.Kind().String())
+		}
+		if itemType != nil && v.Type() != itemType {
+			return errors.New("Invalid CSV result type: " + v.Type().String() + ", expected " + itemType.String())
+		}
+		if columns == nil {
+			var header []string
+			itemType = v.Type()
+			columns, header = csvColumns(itemType)
+			if err := cw.Write(header); err != nil {
+				return err
+			}
+		}
+		row := make([]string, len(columns))
+		for i, f := range columns {
+			row[i] = fmt.Sprint(v.Field(f).Interface())
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// writeResults calls write for the items streamed by Results in the
+// background until the first error
+func (c *Collector) writeResults(write func(interface{}) error) <-chan error {
+	results := c.Results()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for item := range results {
+			if err == nil {
+				err = write(item)
+			}
+		}
+		done <- err
+	}()
+	return done
+}
+
+// csvColumns returns the indexes and the column names of the fields of
+// t written by WriteResultsCSV
+func csvColumns(t reflect.Type) ([]int, []string) {
+	columns := []int{}
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("csv")
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		columns = append(columns, i)
+		names = append(names, name)
+	}
+	return columns, names
+}
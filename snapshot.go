@@ -0,0 +1,54 @@
+package colly
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SnapshotNameFunc returns the file name of the DOM snapshot of a
+// response relative to the snapshot directory
+type SnapshotNameFunc func(*Response) string
+
+// SaveSnapshots enables saving the DOM of every HTML response to dir,
+// e.g. to diff the pages of different crawls when a selector stops
+// matching. The snapshot is the HTML rendered from the parsed document
+// after ParseHiddenContent, ParseComments and the OnDocument callbacks
+// modified it, so it is what the OnHTML callbacks see. name returns the
+// file name of the snapshot of a response. If name is nil, snapshots are
+// named "xx/hash.html", where hash is the hex SHA-1 hash of the URL and
+// xx its first two characters.
+// Snapshots are overwritten on every crawl. Write errors are passed to
+// the OnHTMLError callbacks. An empty dir disables saving snapshots.
+func (c *Collector) SaveSnapshots(dir string, name SnapshotNameFunc) {
+	c.snapshotDir = dir
+	c.snapshotName = name
+}
+
+// saveSnapshot writes the rendered doc of resp to the snapshot directory
+func (c *Collector) saveSnapshot(doc *goquery.Document, resp *Response) error {
+	name := c.snapshotName
+	if name == nil {
+		name = snapshotFileName
+	}
+	filename := path.Join(c.snapshotDir, name(resp))
+	if err := os.MkdirAll(path.Dir(filename), 0750); err != nil {
+		return err
+	}
+	html, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, []byte(html), 0644)
+}
+
+// snapshotFileName names snapshots by the SHA-1 hash of their URL
+func snapshotFileName(resp *Response) string {
+	sum := sha1.Sum([]byte(resp.Request.URL.String()))
+	hash := hex.EncodeToString(sum[:])
+	return path.Join(hash[:2], hash+".html")
+}
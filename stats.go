@@ -0,0 +1,207 @@
+package colly
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of the
+// latency histograms. Latencies above the last bucket are
+// counted in an overflow bucket.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// HostStats contains the latency statistics of the requests made to a host.
+// Percentiles are estimated from a fixed bucket histogram, so they are
+// the upper bounds of the buckets containing them.
+type HostStats struct {
+	// Requests is the number of the measured requests
+	Requests int
+	// Mean is the average latency of the requests
+	Mean time.Duration
+	// Max is the highest latency of the requests
+	Max time.Duration
+	// P50 is the estimated median latency of the requests
+	P50 time.Duration
+	// P90 is the estimated 90th percentile latency of the requests
+	P90 time.Duration
+	// P99 is the estimated 99th percentile latency of the requests
+	P99 time.Duration
+}
+
+type latencyHistogram struct {
+	buckets []int
+	count   int
+	sum     time.Duration
+	max     time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		buckets: make([]int, len(latencyBuckets)+1),
+	}
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	limit := int(p*float64(h.count) + 0.5)
+	if limit < 1 {
+		limit = 1
+	}
+	n := 0
+	for i, c := range h.buckets {
+		n += c
+		if n >= limit {
+			if i == len(latencyBuckets) || latencyBuckets[i] > h.max {
+				return h.max
+			}
+			return latencyBuckets[i]
+		}
+	}
+	return h.max
+}
+
+func (h *latencyHistogram) stats() *HostStats {
+	return &HostStats{
+		Requests: h.count,
+		Mean:     h.sum / time.Duration(h.count),
+		Max:      h.max,
+		P50:      h.percentile(0.5),
+		P90:      h.percentile(0.9),
+		P99:      h.percentile(0.99),
+	}
+}
+
+// HostStats returns the latency statistics of the requests made to host
+// (e.g. "example.com:8080" if the URL contains a port) or nil if no
+// requests were made to host. The latency of a request is the time until
+// its response headers are received, without the delays of the matching
+// LimitRule and the download of the body. Requests failed without a
+// response and responses loaded from the cache are not counted.
+func (c *Collector) HostStats(host string) *HostStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	h, ok := c.latencies[host]
+	if !ok {
+		return nil
+	}
+	return h.stats()
+}
+
+func (c *Collector) recordLatency(host string, d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.latencies == nil {
+		c.latencies = make(map[string]*latencyHistogram)
+	}
+	h, ok := c.latencies[host]
+	if !ok {
+		h = newLatencyHistogram()
+		c.latencies[host] = h
+	}
+	h.add(d)
+}
+
+// ProfileEntry contains the time spent in an extraction step during the
+// crawl. See Collector.EnableProfiling.
+type ProfileEntry struct {
+	// Name identifies the step: the selector of an OnHTML callback or
+	// "unmarshal " followed by the type of an HTMLElement.Unmarshal call.
+	// The time of an OnHTML callback includes the time of the
+	// HTMLElement.Unmarshal calls made by it.
+	Name string
+	// Calls is the number of the measured calls
+	Calls int
+	// Total is the time spent in the calls
+	Total time.Duration
+	// Max is the longest call
+	Max time.Duration
+}
+
+type profileByTotal []*ProfileEntry
+
+func (p profileByTotal) Len() int           { return len(p) }
+func (p profileByTotal) Less(i, j int) bool { return p[i].Total > p[j].Total }
+func (p profileByTotal) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// EnableProfiling enables or disables measuring the time spent in the
+// OnHTML callbacks by selector, including finding the matching elements,
+// and in the HTMLElement.Unmarshal calls by type. The measurements are
+// reported by ProfileReport.
+func (c *Collector) EnableProfiling(enable bool) {
+	var profiling uint32
+	if enable {
+		profiling = 1
+	}
+	atomic.StoreUint32(&c.profiling, profiling)
+}
+
+func (c *Collector) isProfiling() bool {
+	return atomic.LoadUint32(&c.profiling) == 1
+}
+
+// ProfileReport returns the time spent in the extraction steps measured
+// since profiling was enabled, aggregated across the crawl and sorted by
+// the total time in decreasing order. Unmarshal calls made by OnHTML
+// callbacks are counted by both entries, so the totals can add up to more
+// than the time spent.
+func (c *Collector) ProfileReport() []ProfileEntry {
+	c.profileLock.Lock()
+	entries := make([]*ProfileEntry, 0, len(c.profile))
+	for _, e := range c.profile {
+		entries = append(entries, e)
+	}
+	sort.Sort(profileByTotal(entries))
+	report := make([]ProfileEntry, len(entries))
+	for i, e := range entries {
+		report[i] = *e
+	}
+	c.profileLock.Unlock()
+	return report
+}
+
+func (c *Collector) recordProfile(name string, d time.Duration) {
+	c.profileLock.Lock()
+	defer c.profileLock.Unlock()
+	if c.profile == nil {
+		c.profile = make(map[string]*ProfileEntry)
+	}
+	e, ok := c.profile[name]
+	if !ok {
+		e = &ProfileEntry{Name: name}
+		c.profile[name] = e
+	}
+	e.Calls++
+	e.Total += d
+	if d > e.Max {
+		e.Max = d
+	}
+}
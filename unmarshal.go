@@ -1,24 +1,446 @@
 package colly
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"math"
+	"math/big"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
 )
 
-// Unmarshal is a shorthand for colly.UnmarshalHTML
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+var selectionType = reflect.TypeOf((*goquery.Selection)(nil))
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+var timeType = reflect.TypeOf(time.Time{})
+
+var urlType = reflect.TypeOf(url.URL{})
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+var bigFloatType = reflect.TypeOf(big.Float{})
+
+// extractPatterns are the patterns of the "email" and "phone" values of
+// the "extract" struct tag
+var extractPatterns = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	"phone": regexp.MustCompile(`\+?\(?\d[\d ().-]{5,}\d`),
+}
+
+var rangeNumberRegexp = regexp.MustCompile(`-?\d[\d,]*(?:\.\d+)?`)
+
+var unitValueRegexp = regexp.MustCompile(`^(-?\d[\d,]*(?:\.\d+)?|-?\.\d+)\s*(.*)$`)
+
+// unit is a unit of measurement of the "unit" struct tag. factor is the
+// value of the unit in its base unit.
+type unit struct {
+	base   string
+	factor float64
+}
+
+var unitsLock = &sync.RWMutex{}
+
+// units are the units of the "unit" struct tag keyed by lowercase name
+var units = map[string]unit{
+	"mg": {"g", 0.001}, "g": {"g", 1}, "gram": {"g", 1}, "grams": {"g", 1},
+	"kg": {"g", 1000}, "kilogram": {"g", 1000}, "kilograms": {"g", 1000},
+	"t": {"g", 1e6}, "oz": {"g", 28.349523125}, "ounce": {"g", 28.349523125}, "ounces": {"g", 28.349523125},
+	"lb": {"g", 453.59237}, "lbs": {"g", 453.59237}, "pound": {"g", 453.59237}, "pounds": {"g", 453.59237},
+	"mm": {"m", 0.001}, "cm": {"m", 0.01}, "m": {"m", 1}, "meter": {"m", 1}, "meters": {"m", 1},
+	"metre": {"m", 1}, "metres": {"m", 1}, "km": {"m", 1000},
+	"in": {"m", 0.0254}, "inch": {"m", 0.0254}, "inches": {"m", 0.0254}, "\"": {"m", 0.0254},
+	"ft": {"m", 0.3048}, "foot": {"m", 0.3048}, "feet": {"m", 0.3048}, "yd": {"m", 0.9144}, "mi": {"m", 1609.344},
+	"ml": {"l", 0.001}, "cl": {"l", 0.01}, "dl": {"l", 0.1}, "l": {"l", 1}, "liter": {"l", 1}, "liters": {"l", 1},
+	"litre": {"l", 1}, "litres": {"l", 1}, "fl oz": {"l", 0.0295735295625}, "gal": {"l", 3.785411784},
+}
+
+// RegisterUnit registers a unit of measurement for the "unit" struct tag.
+// factor is the value of the unit in base, which must be the base of
+// the compatible units: "g" for mass, "m" for length and "l" for volume,
+// or a new base, e.g. RegisterUnit("stone", "g", 6350.29318) or
+// RegisterUnit("TB", "B", 1e12) along with RegisterUnit("B", "B", 1).
+// Unit names are case-insensitive.
+func RegisterUnit(name, base string, factor float64) {
+	unitsLock.Lock()
+	units[strings.ToLower(name)] = unit{strings.ToLower(base), factor}
+	unitsLock.Unlock()
+}
+
+// convertUnit converts a number followed by a unit (e.g. "2.5 kg") to the
+// target unit. Numbers without a unit are in the target unit.
+func convertUnit(val, target string) (float64, error) {
+	m := unitValueRegexp.FindStringSubmatch(strings.TrimSpace(val))
+	if m == nil {
+		return 0, errors.New("invalid number: " + val)
+	}
+	n, err := strconv.ParseFloat(strings.Replace(m[1], ",", "", -1), 64)
+	if err != nil {
+		return 0, err
+	}
+	unitsLock.RLock()
+	defer unitsLock.RUnlock()
+	to, ok := units[strings.ToLower(target)]
+	if !ok {
+		return 0, errors.New("unknown unit " + target)
+	}
+	name := strings.ToLower(strings.TrimRight(m[2], ". "))
+	if name == "" {
+		return n, nil
+	}
+	from, ok := units[name]
+	if !ok {
+		return 0, errors.New("unknown unit " + m[2])
+	}
+	if from.base != to.base {
+		return 0, errors.New("cannot convert " + m[2] + " to " + target)
+	}
+	return n * from.factor / to.factor, nil
+}
+
+var durationWordRegexp = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(days?|d|hours?|hrs?|h|minutes?|mins?|m|seconds?|secs?|s)\b`)
+
+// TransformFunc is a type alias for the named string transformations
+// used by the "pipe" struct tag. arg is the part of the pipe step
+// after the first colon, e.g. "_,-" for "replace:_,-".
+type TransformFunc func(value, arg string) (string, error)
+
+var builtinTransforms = map[string]TransformFunc{
+	"trim": func(v, _ string) (string, error) {
+		return strings.TrimSpace(v), nil
+	},
+	"lower": func(v, _ string) (string, error) {
+		return strings.ToLower(v), nil
+	},
+	"upper": func(v, _ string) (string, error) {
+		return strings.ToUpper(v), nil
+	},
+	"title": func(v, _ string) (string, error) {
+		return strings.Title(strings.ToLower(v)), nil
+	},
+	"replace": func(v, arg string) (string, error) {
+		parts := strings.SplitN(arg, ",", 2)
+		if len(parts) != 2 {
+			return "", errors.New("Invalid replace arguments: " + arg)
+		}
+		return strings.Replace(v, parts[0], parts[1], -1), nil
+	},
+	"regex": func(v, arg string) (string, error) {
+		r, err := regexp.Compile(arg)
+		if err != nil {
+			return "", err
+		}
+		m := r.FindStringSubmatch(v)
+		switch {
+		case m == nil:
+			return "", nil
+		case len(m) > 1:
+			return m[1], nil
+		}
+		return m[0], nil
+	},
+}
+
+// unmarshaller holds the configuration of an unmarshalling
+type unmarshaller struct {
+	transforms   map[string]TransformFunc
+	requestURL   string
+	request      *Request
+	body         []byte
+	sourceRoot   *html.Node
+	sourceTexts  map[*html.Node]string
+	jsonSelector string
+	types        map[string]interface{}
+	discardGroup bool
+}
+
+// Decoder unmarshals HTML like UnmarshalHTML with additional options
+type Decoder struct {
+	// JSONSelector is the selector template of the fields without a
+	// "selector" tag. "{}" is replaced by the name of the "json" tag of
+	// the field, e.g. ".{}" or "[data-field={}]", so structs shared with
+	// JSON decoding need no selector tags. Fields without a "json" tag
+	// or with `json:"-"` are unmarshalled as fields without a selector.
+	JSONSelector string
+	// Types maps the discriminator values of the elements of []interface{}
+	// fields with a "discriminator" tag to the prototypes of their types,
+	// e.g. {"article": Article{}, "ad": &Ad{}}. Elements are unmarshalled
+	// to new values of the type of the prototype, so pointer prototypes
+	// produce pointers.
+	Types map[string]interface{}
+	// DiscardIncompleteGroups discards the structs whose groups of fields
+	// tagged by "group" are incomplete instead of returning an error.
+	// Discarded elements are left out of slices and maps, and discarded
+	// struct fields are left unchanged. If the unmarshalled value itself
+	// is discarded, Unmarshal leaves it unchanged and returns
+	// ErrIncompleteGroup.
+	DiscardIncompleteGroups bool
+}
+
+// Unmarshal unmarshals s to v like UnmarshalHTML using the options of d
+func (d *Decoder) Unmarshal(v interface{}, s *goquery.Selection) error {
+	return (&unmarshaller{jsonSelector: d.JSONSelector, types: d.Types, discardGroup: d.DiscardIncompleteGroups}).unmarshalRoot(v, s)
+}
+
+// fieldSelector returns the "selector" tag of a field or the selector
+// derived from its "json" tag
+func (u *unmarshaller) fieldSelector(attrT reflect.StructField) string {
+	if selector, ok := attrT.Tag.Lookup("selector"); ok || u.jsonSelector == "" {
+		return selector
+	}
+	name := strings.Split(attrT.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return ""
+	}
+	return strings.Replace(u.jsonSelector, "{}", name, -1)
+}
+
+// Unmarshal is a shorthand for colly.UnmarshalHTML. Unmarshal also
+// supports the transforms registered by Collector.RegisterTransform.
 func (h *HTMLElement) Unmarshal(v interface{}) error {
-	return UnmarshalHTML(v, h.DOM)
+	u := &unmarshaller{}
+	if h.Request != nil && h.Request.collector != nil {
+		u.transforms = h.Request.collector.transforms()
+	}
+	if h.Request != nil && h.Request.URL != nil {
+		u.requestURL = h.Request.URL.String()
+		u.request = h.Request
+	}
+	if h.Response != nil {
+		u.body = h.Response.Body
+	}
+	if h.Request != nil && h.Request.collector != nil && h.Request.collector.isProfiling() {
+		start := time.Now()
+		defer func() {
+			h.Request.collector.recordProfile("unmarshal "+reflect.TypeOf(v).String(), time.Since(start))
+		}()
+	}
+	return u.unmarshalRoot(v, h.DOM)
 }
 
 // UnmarshalHTML declaratively extracts text or attributes to a struct from
 // HTML response using struct tags composed of css selectors.
 // Allowed struct tags:
-//  - "selector" (required): CSS (goquery) selector of the desired data
+//  - "selector" (required): CSS (goquery) selector of the desired data.
+//     Decoder.JSONSelector derives the selectors of the fields without
+//     a selector tag from their "json" tags.
 //  - "attr" (optional): Selects the matching element's attribute's value.
 //     Leave it blank or omit to get the text of the element.
+//     "#index" sets an int field to the zero-based index of the element
+//     within its matched set (e.g. the position of a struct in a slice).
+//     "#count" sets an int field to the number of elements matching the
+//     selector.
+//     "#url" sets a string field to the URL of the response after
+//     redirects. It is set only by HTMLElement.Unmarshal.
+//     "#outerHTML" sets a string field to the HTML source of the
+//     unmarshalled element, or of the first element matching the
+//     selector if there is one, e.g. to keep the provenance of a record
+//     with `attr:"#outerHTML" maxlen:"200"` next to a `attr:"#url"`
+//     field. The "maxlen" and "pipe" tags apply to the HTML.
+//     "#depth" sets an int field to the number of ancestor elements of
+//     the matching element, e.g. 1 for <body>. Slice fields get the
+//     depth of every matching element.
+//     "#aria-label" sets a string field to the accessible label of the
+//     matching element given by its "aria-labelledby" or "aria-label"
+//     attribute. See HTMLElement.AriaLabel.
+//     Attribute names are case-insensitive, e.g. `attr:"aria-Label"`
+//     selects the "aria-label" attribute.
+//  - "presence" (optional): Set it to "true" to set a bool field to
+//     whether the selector matches any element, e.g. `selector:".sale"
+//     presence:"true"`. Set "negate" to "true" as well to set the field
+//     to whether the selector matches nothing, e.g. `selector:".add-to-cart"
+//     presence:"true" negate:"true"` for an OutOfStock field.
+//  - "extract" (optional): Selects the form of the extracted text. "text"
+//     (default) decodes HTML entities, "rawText" keeps the entities of the
+//     source (e.g. "&amp;", "&#38;" and "&nbsp;") so the value can be
+//     embedded in HTML again. The source is only known to
+//     HTMLElement.Unmarshal, UnmarshalHTML encodes "&", "<", ">" and
+//     non-breaking spaces instead.
+//     "innerHTML" and "outerHTML" select the HTML content of the matching
+//     element without or with its own tag.
+//     "email" and "phone" select the first email address or phone number
+//     found in the extracted string, or all of them for slice fields.
+//     Email addresses match `[A-Za-z0-9._%+-]+@domain` where domain has
+//     a top level part of at least two letters. Quoted local parts and
+//     internationalized domains are not supported. Phone numbers are runs
+//     of digits, spaces, dots, dashes and parentheses with an optional
+//     leading "+" and 7 to 15 digits, e.g. "+1 (555) 123-4567". Phone
+//     numbers are not validated, so dates and other long numbers written
+//     like phone numbers also match, and numbers separated by spaces only
+//     are matched as one.
+//     "selectedOption" and "selectedOptionText" select the value or the
+//     text of the selected <option> of the matching <select> element,
+//     which is the option with the "selected" attribute or the first
+//     option if none is selected. Options without a "value" attribute
+//     have their text as value. Slice fields get the selected options
+//     of every matching element, e.g. all the selected options of a
+//     <select multiple>.
+//  - "fallback" (optional): Extracts the value from an ancestor if the
+//     value of the matching element is empty. "parent" selects the parent
+//     element and "closest:selector" the closest ancestor matching the
+//     selector, e.g. `fallback:"closest:.price-container"`.
+//  - "invalid" (optional): Set it to "skip" to leave out the elements of
+//     scalar slice fields whose values cannot be parsed, e.g. malformed
+//     links of a []*url.URL field, instead of returning an error.
+//  - "root" (optional): Resolves the selector of the field against the
+//     elements of the whole document matching the root selector instead
+//     of the unmarshalled selection, e.g. `root:"#sidebar" selector:".price"`,
+//     so a struct can gather data from unrelated containers of the page.
+//  - "column" (optional): Scopes the field of a struct unmarshalled from
+//     a table row to the cell of the column whose "thead th" header cell
+//     has the text of the tag, compared case-insensitively, e.g.
+//     `column:"Price"` for the rows of `selector:"tbody tr"`. The value
+//     is extracted from the cell itself or from its descendants matching
+//     the selector of the field. Columns are matched by position, so
+//     cells spanning multiple columns are not supported.
+//  - "group" (optional): Groups fields which must be set together, e.g.
+//     `group:"contact"` on the email and phone fields of a record. If some
+//     but not all the fields of a group have non-zero values, the struct
+//     is incomplete and UnmarshalHTML returns an error naming the first
+//     missing field. Decoder.DiscardIncompleteGroups discards incomplete
+//     structs instead.
+//  - "discriminator" (optional): Unmarshals the elements of a
+//     []interface{} field to the struct types registered in Decoder.Types
+//     for their discriminator values. The value is extracted from the
+//     element itself or from its descendants matching the selector of the
+//     tag, from the attribute named by the "discriminatorAttr" tag or
+//     from the text, e.g. `selector:".feed > li" discriminator:"" discriminatorAttr:"data-type"`.
+//     Elements with unregistered values return an error, unless the
+//     "invalid" tag is set to "skip".
+//  - "resolve" (optional): Set it to "true" to resolve the extracted
+//     relative URLs of string and URL fields like Request.AbsoluteURL,
+//     against the <base href> or the URL of the response, e.g. `selector:"a" attr:"href" resolve:"true"`. URLs are
+//     resolved only by HTMLElement.Unmarshal.
+//  - "css" (optional): Selects the value of a CSS property from the matching
+//     element's inline "style" attribute, e.g. `css:"background-image"`.
+//     url(...) values are unwrapped to the URL.
+//  - "srcsetPick" (optional): Set it to "largest" or "smallest" to select
+//     the URL of the largest or the smallest candidate of the extracted
+//     srcset value, e.g. `attr:"srcset" srcsetPick:"largest"`. The value
+//     of the "src" attribute is used if there are no candidates.
+//  - "classMap" (optional): Sets the value according to the CSS classes of
+//     the matching element, e.g. `classMap:"badge--active=active,badge--expired=expired"`.
+//     The value of the first class present on the element is used.
+//     The value is empty if none of the classes is present.
+//  - "pipe" (optional): Transforms the extracted string by a "|" separated
+//     chain of named operations before it is stored or converted,
+//     e.g. `pipe:"trim|lower|replace:_,-"`. Arguments follow the name
+//     after a colon. A literal "|" in an argument must be escaped as "\|".
+//     Built-in operations: trim, lower, upper, title, replace:old,new and
+//     regex:pattern (returns the first submatch or the whole match).
+//  - "regex" (optional): Matches a regular expression against the extracted
+//     string of a struct field and sets the fields of the struct named after
+//     the named capture groups, e.g. `regex:"(?P<Score>\\d\\.\\d) out of (?P<Max>\\d)"`
+//  - "dataAttrs", "dataPrefix" (optional): Set dataAttrs to "true" to set
+//     the fields of a struct field to the data attributes of the matching
+//     element named after the fields, e.g. the Id and UnitPrice fields to
+//     the values of "data-id" and "data-unit-price". dataPrefix sets a
+//     different prefix than "data-", e.g. `dataPrefix:"data-product-"`.
+//     The "attr" tag of a field overrides its attribute name. The fields
+//     of the struct must have scalar types.
+//  - "rangeSep" (optional): Splits the extracted string of a struct field
+//     at the separator and sets the Min and Max fields of the struct to
+//     the parts, e.g. `rangeSep:"–"` for "$10 – $25". Both fields are set
+//     to the whole value if it contains no separator. The first number of
+//     each part is used for numeric fields, so currency symbols and units
+//     are skipped and "," thousands separators are removed. Other fields,
+//     e.g. time.Time, are set to the trimmed parts.
+//  - "pairSep", "kvSep" (optional): Splits the extracted string of a struct
+//     or map field into key-value pairs, e.g. `attr:"data-info" pairSep:";" kvSep:"="`
+//     for "color=red;size=42". kvSep defaults to "=". Struct fields are
+//     matched by their "key" tag or, case-insensitively, by their name.
+//  - "decode" (optional): Set it to "json" to decode the extracted string
+//     into the field by encoding/json, e.g.
+//     `selector:"#app" attr:"data-props" decode:"json"`. "jwt" decodes
+//     the JSON payload of a JSON Web Token the same way, without verifying
+//     its signature. "base64" decodes standard or URL-safe base64 with or
+//     without padding into string and []byte fields. Fields are left
+//     unchanged if the value is empty.
+//  - "validate" (optional): Comma separated list of rules checked after
+//     the field has been set. UnmarshalHTML returns an error naming the
+//     field if a rule fails. Rules:
+//       - nonempty: strings, slices and maps must not be empty, pointers
+//         and interfaces must not be nil, other values must not be zero
+//       - min=N, max=N: bounds of numbers or the length of strings,
+//         slices and maps
+//       - url: non-empty strings must be absolute URLs
+//  - "minItems" (optional): The minimum number of elements of a slice
+//     field, e.g. `selector:".gallery img" attr:"src" minItems:"1"`.
+//     UnmarshalHTML returns an error naming the field and the counts if
+//     fewer elements are unmarshalled. Elements left out by the "invalid"
+//     tag or by Decoder.DiscardIncompleteGroups are not counted.
+//  - "zip" (optional): Fills the slice fields of a struct field in
+//     parallel. Every element matching the selector of the struct field
+//     (e.g. a table row) appends one value to each slice field, taken
+//     from the first element matching the selector of the slice field
+//     inside it. Set it to "pad" to append the zero value for missing
+//     values or to "error" to return an error instead, so the slices
+//     always have equal lengths.
+//  - "keyAttr", "keySelector" (required for maps): The key of a map field
+//     is the value of the keyAttr attribute or the text of the keySelector
+//     child of each matching element. The values are unmarshalled from
+//     the matching elements. Elements with an empty key are skipped.
+//  - "duplicate" (optional): Set it to "error" to return an error instead
+//     of overwriting an existing key of a map field.
+//  - "filter" (optional): Keeps only the elements matching the selector
+//     which also match the filter selector, e.g. `selector:"input"
+//     filter:"[checked]" attr:"data-id"` collects the data-id attribute
+//     of the checked inputs. Attribute presence selectors, pseudo-classes
+//     and other selectors supported by goquery can be used.
+//  - "contains" (optional): Keeps only the elements matching the selector
+//     whose text contains the value, e.g. `selector:".badge" contains:"Sale"
+//     attr:"#count"` counts the sale badges. Set "containsIgnoreCase" to
+//     "true" to compare case-insensitively.
+//  - "index" (optional): Selects the matching element with the given
+//     zero-based index for scalar fields instead of the first one.
+//     Negative indexes count from the last element, e.g. `index:"-1"`
+//     selects the last match. Out of range indexes leave the field empty.
+//  - "locale", "decimal", "thousands" (optional): Normalizes numbers
+//     formatted with the given decimal and thousands separators before
+//     they are parsed, e.g. `decimal:"," thousands:"."` for "1.234,56".
+//     A `decimal:","` without "thousands" implies "." thousands
+//     separators. "locale" selects the separators of a language, e.g.
+//     `locale:"de"`.
+//     Supported locales: en, ja, zh, ko (1,234.5), de, es, it, nl, pt, da,
+//     tr, id (1.234,5) and fr, ru, pl, cs, sv, fi, no (1 234,5).
+//  - "format" (optional): The format of time.Duration fields. Durations
+//     are parsed by time.ParseDuration by default, allowing spaces between
+//     the units (e.g. "2h 30m"). "clock" parses "h:mm:ss" and "mm:ss"
+//     values and "words" parses values like "1 hour 30 minutes" or
+//     "90 mins".
+//     For time.Time fields and the elements of []time.Time fields it is
+//     the layout used by time.Parse, e.g. `format:"Jan 2, 2006"`.
+//     time.Time values are parsed as RFC 3339 by default.
+//  - "unit" (optional): Converts numbers followed by a unit of
+//     measurement to the unit of the tag, e.g. `unit:"g"` stores 2500 for
+//     "2.5 kg" and 1360.77711 for "3 lbs". Numbers without a unit are in
+//     the unit of the tag. Integer fields are rounded. Mass (mg, g, kg, t,
+//     oz, lb), length (mm, cm, m, km, in, ft, yd, mi) and volume (ml, cl,
+//     dl, l, fl oz, gal) units and some of their long names are built in,
+//     RegisterUnit adds more.
+//  - "enum" (optional): Maps the extracted string to the value stored in
+//     the field, e.g. `enum:"active=1,expired=2"` for a field of a named
+//     integer type. UnmarshalHTML returns an error for unknown strings
+//     unless a default value is given by the "*" key, e.g. `enum:"active=1,*=0"`.
+//  - "maxlen" (optional): Truncates the extracted string to the given number
+//     of runes and appends an ellipsis ("…") if it was longer.
 //
 // Example struct declaration:
 //
@@ -28,8 +450,60 @@ func (h *HTMLElement) Unmarshal(v interface{}) error {
 //   	Struct  *Nested  `selector:"div > div"`
 //   }
 //
-// Supported types: struct, *struct, string, []string
+// Supported types: struct, *struct, string, bool, int, uint, float types,
+// interface{}, time.Duration, url.URL, *url.URL, *big.Int, *big.Float, []byte,
+// *goquery.Selection, the types implementing encoding.TextUnmarshaler, []struct,
+// []*struct, slices of the supported scalar types, maps with string keys
+// and struct, *struct or scalar values and slices of maps with string keys
+// and scalar values.
+//
+// *goquery.Selection fields are set to the unmarshalled element if their
+// selector is "self" or empty and to the matches of their selector
+// otherwise, e.g. to process the rows of a []struct manually after
+// the unmarshalling.
+//
+// []byte fields are set to the decoded payload of "data:" URIs like
+// `data:image/png;base64,iVBORw0...` and to the bytes of the extracted
+// string otherwise.
+//
+// Slices contain an element for every match of the selector at any depth
+// below the selection, in document order. Matches nested in other matches
+// are included as separate elements.
+//
+// Slices of maps like []map[string]string hold the rows of tables with
+// variable columns, e.g. `selector:"tbody tr"`. The cells of every row are
+// keyed by the text of the "thead th" header cell of their column. Cells
+// without a header are ignored.
+//
+// interface{} values are set on a best-effort basis similar to JSON
+// decoding: numeric-looking values are stored as float64, "true" and
+// "false" as bool and anything else as string.
 func UnmarshalHTML(v interface{}, s *goquery.Selection) error {
+	return (&unmarshaller{}).unmarshalRoot(v, s)
+}
+
+// unmarshalRoot unmarshals s to v. If incomplete groups are discarded,
+// v is unmarshalled to a copy, so it is left unchanged if it is discarded.
+func (u *unmarshaller) unmarshalRoot(v interface{}, s *goquery.Selection) error {
+	if !u.discardGroup {
+		return u.unmarshal(v, s, 0)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Invalid type or nil-pointer")
+	}
+	n := reflect.New(rv.Type().Elem())
+	n.Elem().Set(rv.Elem())
+	if err := u.unmarshal(n.Interface(), s, 0); err != nil {
+		return err
+	}
+	rv.Elem().Set(n.Elem())
+	return nil
+}
+
+// unmarshal unmarshals s to v. index is the position of s
+// within the matched set of its selector.
+func (u *unmarshaller) unmarshal(v interface{}, s *goquery.Selection, index int) error {
 	rv := reflect.ValueOf(v)
 
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
@@ -44,31 +518,257 @@ func UnmarshalHTML(v interface{}, s *goquery.Selection) error {
 		if !attrV.CanAddr() || !attrV.CanSet() {
 			continue
 		}
-		if err := unmarshalAttr(s, attrV, st.Field(i)); err != nil {
+		if err := u.unmarshalAttr(s, attrV, st.Field(i), index); err != nil {
 			return err
 		}
+		if minItems := st.Field(i).Tag.Get("minItems"); minItems != "" && attrV.Kind() == reflect.Slice {
+			n, err := strconv.Atoi(minItems)
+			if err != nil {
+				return errors.New("Invalid minItems value: " + minItems)
+			}
+			if attrV.Len() < n {
+				return errors.New("Too few elements of field " + st.Field(i).Name + ": " + strconv.Itoa(attrV.Len()) + ", expected at least " + minItems)
+			}
+		}
+		if rules := st.Field(i).Tag.Get("validate"); rules != "" {
+			if err := validateField(attrV, rules); err != nil {
+				return errors.New("Invalid value of field " + st.Field(i).Name + ": " + err.Error())
+			}
+		}
+	}
+	return u.checkGroups(sv, st)
+}
+
+// checkGroups returns an error if some but not all the fields of a group
+// tagged by "group" are set
+func (u *unmarshaller) checkGroups(sv reflect.Value, st reflect.Type) error {
+	set := map[string]bool{}
+	missing := map[string]string{}
+	groups := []string{}
+	for i := 0; i < sv.NumField(); i++ {
+		group := st.Field(i).Tag.Get("group")
+		if group == "" {
+			continue
+		}
+		if _, ok := set[group]; !ok {
+			set[group] = false
+			groups = append(groups, group)
+		}
+		if isEmptyValue(sv.Field(i)) {
+			if missing[group] == "" {
+				missing[group] = st.Field(i).Name
+			}
+		} else {
+			set[group] = true
+		}
+	}
+	for _, group := range groups {
+		if set[group] && missing[group] != "" {
+			if u.discardGroup {
+				return ErrIncompleteGroup
+			}
+			return errors.New("Incomplete group " + group + ": missing field " + missing[group])
+		}
 	}
 	return nil
 }
 
-func unmarshalAttr(s *goquery.Selection, attrV reflect.Value, attrT reflect.StructField) error {
-	selector := attrT.Tag.Get("selector")
+func (u *unmarshaller) unmarshalAttr(s *goquery.Selection, attrV reflect.Value, attrT reflect.StructField, index int) error {
+	selector := u.fieldSelector(attrT)
 	htmlAttr := attrT.Tag.Get("attr")
+	if root := attrT.Tag.Get("root"); root != "" {
+		s = documentRoot(s).Find(root)
+	}
+	if column := attrT.Tag.Get("column"); column != "" {
+		s = tableCell(s, column)
+		if selector == "" && isScalar(attrV.Type()) {
+			return u.setScalar(attrV, s, htmlAttr, attrT)
+		}
+	}
+	if attrV.Type() == selectionType {
+		if selector == "" || selector == "self" {
+			attrV.Set(reflect.ValueOf(s))
+		} else {
+			attrV.Set(reflect.ValueOf(findMatches(s, selector, attrT)))
+		}
+		return nil
+	}
+	if htmlAttr == "#index" {
+		return setSpecialInt(attrV, "#index", index)
+	}
+	if htmlAttr == "#url" {
+		if attrV.Kind() != reflect.String {
+			return errors.New("Invalid type for #url: " + attrV.String())
+		}
+		attrV.SetString(u.requestURL)
+		return nil
+	}
+	if htmlAttr == "#outerHTML" {
+		if attrV.Kind() != reflect.String {
+			return errors.New("Invalid type for #outerHTML: " + attrV.String())
+		}
+		sel := s.First()
+		if selector != "" {
+			sel = findMatches(s, selector, attrT).First()
+		}
+		if sel.Length() == 0 {
+			return nil
+		}
+		val, err := goquery.OuterHtml(sel)
+		if err != nil {
+			return err
+		}
+		if val, err = u.transformValue(sel, val, attrT); err != nil {
+			return err
+		}
+		attrV.SetString(val)
+		return nil
+	}
+	if htmlAttr == "#count" {
+		return setSpecialInt(attrV, "#count", findMatches(s, selector, attrT).Length())
+	}
+	if attrT.Tag.Get("presence") == "true" {
+		if attrV.Kind() != reflect.Bool {
+			return errors.New("Invalid type for presence: " + attrV.String())
+		}
+		present := findMatches(s, selector, attrT).Length() > 0
+		attrV.SetBool(present != (attrT.Tag.Get("negate") == "true"))
+		return nil
+	}
+	if decode := attrT.Tag.Get("decode"); decode == "json" || decode == "jwt" || decode == "base64" {
+		sel, err := selectScalar(s, selector, attrT)
+		if err != nil {
+			return err
+		}
+		val, err := u.fieldValue(sel, htmlAttr, attrT)
+		if err != nil || val == "" {
+			return err
+		}
+		switch decode {
+		case "base64":
+			b, err := decodeBase64(val)
+			if err != nil {
+				return errors.New("Invalid base64 value of field " + attrT.Name + ": " + err.Error())
+			}
+			switch {
+			case attrV.Type() == bytesType:
+				attrV.SetBytes(b)
+			case attrV.Kind() == reflect.String:
+				attrV.SetString(string(b))
+			default:
+				return errors.New("Invalid type for base64 decoding: " + attrV.Type().String())
+			}
+			return nil
+		case "jwt":
+			payload, err := jwtPayload(val)
+			if err != nil {
+				return errors.New("Invalid JWT value of field " + attrT.Name + ": " + err.Error())
+			}
+			val = string(payload)
+		}
+		if err := json.Unmarshal([]byte(val), attrV.Addr().Interface()); err != nil {
+			return errors.New("Invalid JSON value of field " + attrT.Name + ": " + err.Error())
+		}
+		return nil
+	}
+	if attrV.Type() == bytesType {
+		sel, err := selectScalar(s, selector, attrT)
+		if err != nil {
+			return err
+		}
+		val, err := u.fieldValue(sel, htmlAttr, attrT)
+		if err != nil {
+			return err
+		}
+		b, err := decodeDataURI(val)
+		if err != nil {
+			return err
+		}
+		attrV.SetBytes(b)
+		return nil
+	}
+	if isScalar(attrV.Type()) {
+		sel, err := selectScalar(s, selector, attrT)
+		if err != nil {
+			return err
+		}
+		return u.setScalar(attrV, sel, htmlAttr, attrT)
+	}
+	if pattern := attrT.Tag.Get("regex"); pattern != "" && attrV.Kind() == reflect.Struct {
+		sel, err := selectScalar(s, selector, attrT)
+		if err != nil {
+			return err
+		}
+		val, err := u.fieldValue(sel, htmlAttr, attrT)
+		if err != nil {
+			return err
+		}
+		return unmarshalRegexGroups(val, pattern, attrV)
+	}
+	if prefix := attrT.Tag.Get("dataPrefix"); (prefix != "" || attrT.Tag.Get("dataAttrs") == "true") && attrV.Kind() == reflect.Struct {
+		sel, err := selectScalar(s, selector, attrT)
+		if err != nil {
+			return err
+		}
+		if prefix == "" {
+			prefix = "data-"
+		}
+		return unmarshalDataAttrs(sel, prefix, attrV)
+	}
+	if rangeSep := attrT.Tag.Get("rangeSep"); rangeSep != "" && attrV.Kind() == reflect.Struct {
+		sel, err := selectScalar(s, selector, attrT)
+		if err != nil {
+			return err
+		}
+		val, err := u.fieldValue(sel, htmlAttr, attrT)
+		if err != nil {
+			return err
+		}
+		return unmarshalRange(val, rangeSep, attrV)
+	}
+	if zip := attrT.Tag.Get("zip"); zip != "" && attrV.Kind() == reflect.Struct {
+		if zip != "pad" && zip != "error" {
+			return errors.New("Invalid zip value: " + zip)
+		}
+		return u.unmarshalZip(findMatches(s, selector, attrT), attrV, zip == "error")
+	}
+	if pairSep := attrT.Tag.Get("pairSep"); pairSep != "" && (attrV.Kind() == reflect.Struct || attrV.Kind() == reflect.Map) {
+		sel, err := selectScalar(s, selector, attrT)
+		if err != nil {
+			return err
+		}
+		val, err := u.fieldValue(sel, htmlAttr, attrT)
+		if err != nil {
+			return err
+		}
+		kvSep := attrT.Tag.Get("kvSep")
+		if kvSep == "" {
+			kvSep = "="
+		}
+		return unmarshalPairs(val, pairSep, kvSep, attrV)
+	}
+	if extract := attrT.Tag.Get("extract"); (extract == "email" || extract == "phone") && attrV.Kind() == reflect.Slice && isScalar(attrV.Type().Elem()) {
+		return u.unmarshalPatternMatches(findMatches(s, selector, attrT), htmlAttr, extract, attrV, attrT)
+	}
+	if extract := attrT.Tag.Get("extract"); (extract == "selectedOption" || extract == "selectedOptionText") && attrV.Kind() == reflect.Slice && isScalar(attrV.Type().Elem()) {
+		return u.unmarshalSlice(selectedOptions(findMatches(s, selector, attrT)), htmlAttr, attrV, attrT)
+	}
 	// TODO support more types
 	switch attrV.Kind() {
 	case reflect.Slice:
-		if err := unmarshalSlice(s, selector, htmlAttr, attrV); err != nil {
+		if err := u.unmarshalSlice(findMatches(s, selector, attrT), htmlAttr, attrV, attrT); err != nil {
+			return err
+		}
+	case reflect.Map:
+		if err := u.unmarshalMap(s, selector, htmlAttr, attrV, attrT); err != nil {
 			return err
 		}
-	case reflect.String:
-		val := getDOMValue(s.Find(selector), htmlAttr)
-		attrV.Set(reflect.Indirect(reflect.ValueOf(val)))
 	case reflect.Struct:
-		if err := unmarshalStruct(s, selector, attrV); err != nil {
+		if err := u.unmarshalStruct(s, selector, attrV); err != nil {
 			return err
 		}
 	case reflect.Ptr:
-		if err := unmarshalPtr(s, selector, attrV); err != nil {
+		if err := u.unmarshalPtr(s, selector, attrV); err != nil {
 			return err
 		}
 	default:
@@ -77,7 +777,7 @@ func unmarshalAttr(s *goquery.Selection, attrV reflect.Value, attrT reflect.Stru
 	return nil
 }
 
-func unmarshalStruct(s *goquery.Selection, selector string, attrV reflect.Value) error {
+func (u *unmarshaller) unmarshalStruct(s *goquery.Selection, selector string, attrV reflect.Value) error {
 	newS := s
 	if selector != "" {
 		newS = newS.Find(selector)
@@ -86,7 +786,10 @@ func unmarshalStruct(s *goquery.Selection, selector string, attrV reflect.Value)
 		return nil
 	}
 	v := reflect.New(attrV.Type())
-	err := UnmarshalHTML(v.Interface(), newS)
+	err := u.unmarshal(v.Interface(), newS, 0)
+	if err == ErrIncompleteGroup {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -94,7 +797,7 @@ func unmarshalStruct(s *goquery.Selection, selector string, attrV reflect.Value)
 	return nil
 }
 
-func unmarshalPtr(s *goquery.Selection, selector string, attrV reflect.Value) error {
+func (u *unmarshaller) unmarshalPtr(s *goquery.Selection, selector string, attrV reflect.Value) error {
 	newS := s
 	if selector != "" {
 		newS = newS.Find(selector)
@@ -107,7 +810,10 @@ func unmarshalPtr(s *goquery.Selection, selector string, attrV reflect.Value) er
 		return errors.New("Invalid slice type")
 	}
 	v := reflect.New(e)
-	err := UnmarshalHTML(v.Interface(), newS)
+	err := u.unmarshal(v.Interface(), newS, 0)
+	if err == ErrIncompleteGroup {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -115,27 +821,1277 @@ func unmarshalPtr(s *goquery.Selection, selector string, attrV reflect.Value) er
 	return nil
 }
 
-func unmarshalSlice(s *goquery.Selection, selector, htmlAttr string, attrV reflect.Value) error {
+// setScalar sets the scalar value v to the extracted value of s
+func (u *unmarshaller) setScalar(v reflect.Value, s *goquery.Selection, htmlAttr string, attrT reflect.StructField) error {
+	val, err := u.fieldValue(s, htmlAttr, attrT)
+	if err != nil {
+		return err
+	}
+	if attrT.Tag.Get("resolve") == "true" {
+		val = u.resolveURL(val)
+	}
+	if isURLType(v.Type()) {
+		if err := setValue(v, val); err != nil {
+			return errors.New("Invalid URL of field " + attrT.Name + ": " + err.Error())
+		}
+		return nil
+	}
+	if target := attrT.Tag.Get("unit"); target != "" && val != "" {
+		n, err := convertUnit(val, target)
+		if err != nil {
+			return errors.New("Invalid unit of field " + attrT.Name + ": " + err.Error())
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			val = strconv.FormatFloat(math.Floor(n+0.5), 'f', -1, 64)
+		default:
+			val = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+	}
+	if isBigType(v.Type()) {
+		if err := setBig(v, val); err != nil {
+			return errors.New("Invalid number of field " + attrT.Name + ": " + err.Error())
+		}
+		return nil
+	}
+	if v.Type() == durationType && val != "" {
+		d, err := parseDuration(val, attrT.Tag.Get("format"))
+		if err != nil {
+			return errors.New("Invalid duration of field " + attrT.Name + ": " + err.Error())
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if format := attrT.Tag.Get("format"); v.Type() == timeType && format != "" {
+		if val == "" {
+			return nil
+		}
+		t, err := time.Parse(format, val)
+		if err != nil {
+			return errors.New("Invalid time of field " + attrT.Name + ": " + err.Error())
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	return setValue(v, val)
+}
+
+// unmarshalSlice appends an element to attrV for every element of matches
+// Scalar elements are extracted like scalar fields, so the tags
+// transforming the extracted value apply to every element.
+func (u *unmarshaller) unmarshalSlice(matches *goquery.Selection, htmlAttr string, attrV reflect.Value, attrT reflect.StructField) error {
 	if attrV.Pointer() == 0 {
 		v := reflect.MakeSlice(attrV.Type(), 0, 0)
 		attrV.Set(v)
 	}
-	switch attrV.Type().Elem().Kind() {
-	case reflect.String:
-		s.Find(selector).Each(func(_ int, s *goquery.Selection) {
-			val := getDOMValue(s, htmlAttr)
-			attrV.Set(reflect.Append(attrV, reflect.Indirect(reflect.ValueOf(val))))
+	if _, ok := attrT.Tag.Lookup("discriminator"); ok && attrV.Type().Elem().Kind() == reflect.Interface {
+		return u.unmarshalDiscriminated(matches, attrV, attrT)
+	}
+	if isScalar(attrV.Type().Elem()) {
+		skipInvalid := attrT.Tag.Get("invalid") == "skip"
+		var err error
+		matches.EachWithBreak(func(i int, s *goquery.Selection) bool {
+			v := reflect.New(attrV.Type().Elem()).Elem()
+			if err = u.setScalar(v, s, htmlAttr, attrT); err != nil {
+				if skipInvalid {
+					err = nil
+					return true
+				}
+				err = errors.New("Invalid element " + strconv.Itoa(i) + " of field " + attrT.Name + ": " + err.Error())
+				return false
+			}
+			attrV.Set(reflect.Append(attrV, v))
+			return true
 		})
-	default:
+		return err
+	}
+	e := attrV.Type().Elem()
+	if e.Kind() == reflect.Map && e.Key().Kind() == reflect.String && isScalar(e.Elem()) {
+		return u.unmarshalRows(matches, htmlAttr, attrV, attrT)
+	}
+	isPtr := e.Kind() == reflect.Ptr
+	if isPtr {
+		e = e.Elem()
+	}
+	if e.Kind() != reflect.Struct {
 		return errors.New("Invalid slice type")
 	}
+	var err error
+	matches.EachWithBreak(func(i int, s *goquery.Selection) bool {
+		v := reflect.New(e)
+		if err = u.unmarshal(v.Interface(), s, i); err == ErrIncompleteGroup {
+			err = nil
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		if !isPtr {
+			v = v.Elem()
+		}
+		attrV.Set(reflect.Append(attrV, v))
+		return true
+	})
+	return err
+}
+
+// unmarshalDiscriminated appends a value of the type registered for the
+// discriminator value of every element of matches to attrV
+func (u *unmarshaller) unmarshalDiscriminated(matches *goquery.Selection, attrV reflect.Value, attrT reflect.StructField) error {
+	selector := attrT.Tag.Get("discriminator")
+	attr := attrT.Tag.Get("discriminatorAttr")
+	skipUnknown := attrT.Tag.Get("invalid") == "skip"
+	var err error
+	matches.EachWithBreak(func(i int, s *goquery.Selection) bool {
+		d := s
+		if selector != "" {
+			d = s.Find(selector).First()
+		}
+		key := strings.TrimSpace(getDOMValue(d, attr))
+		proto, ok := u.types[key]
+		if !ok {
+			if !skipUnknown {
+				err = errors.New("Unknown discriminator of element " + strconv.Itoa(i) + " of field " + attrT.Name + ": " + key)
+			}
+			return skipUnknown
+		}
+		t := reflect.TypeOf(proto)
+		isPtr := t.Kind() == reflect.Ptr
+		if isPtr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			err = errors.New("Invalid type of discriminator " + key + ": " + t.String())
+			return false
+		}
+		v := reflect.New(t)
+		if err = u.unmarshal(v.Interface(), s, i); err == ErrIncompleteGroup {
+			err = nil
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		if !isPtr {
+			v = v.Elem()
+		}
+		attrV.Set(reflect.Append(attrV, v))
+		return true
+	})
+	return err
+}
+
+// unmarshalPatternMatches appends every email address or phone number
+// found in the extracted values of matches to attrV
+func (u *unmarshaller) unmarshalPatternMatches(matches *goquery.Selection, htmlAttr, extract string, attrV reflect.Value, attrT reflect.StructField) error {
+	if attrV.Pointer() == 0 {
+		attrV.Set(reflect.MakeSlice(attrV.Type(), 0, 0))
+	}
+	var err error
+	matches.EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		for _, m := range findPatterns(getDOMValue(s, htmlAttr), extract) {
+			var val string
+			if val, err = u.transformValue(s, m, attrT); err != nil {
+				return false
+			}
+			v := reflect.New(attrV.Type().Elem()).Elem()
+			if err = setValue(v, val); err != nil {
+				err = errors.New("Invalid element of field " + attrT.Name + ": " + err.Error())
+				return false
+			}
+			attrV.Set(reflect.Append(attrV, v))
+		}
+		return true
+	})
+	return err
+}
+
+// selectedOptions returns the selected <option> elements of the <select>
+// elements of s. The first option is selected if a <select> without the
+// "multiple" attribute has no option with the "selected" attribute.
+func selectedOptions(s *goquery.Selection) *goquery.Selection {
+	return s.Find("option").FilterFunction(func(_ int, opt *goquery.Selection) bool {
+		if _, ok := opt.Attr("selected"); ok {
+			return true
+		}
+		sel := opt.Closest("select")
+		_, multiple := sel.Attr("multiple")
+		return !multiple && sel.Find("option[selected]").Length() == 0 && sel.Find("option").First().IsSelection(opt)
+	})
+}
+
+// findPatterns returns the email addresses or phone numbers in val
+func findPatterns(val, extract string) []string {
+	matches := []string{}
+	for _, m := range extractPatterns[extract].FindAllString(val, -1) {
+		if extract != "phone" || isPhoneNumber(m) {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// isPhoneNumber reports whether a match of the phone pattern has
+// 7 to 15 digits
+func isPhoneNumber(val string) bool {
+	digits := 0
+	for _, r := range val {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 7 && digits <= 15
+}
+
+// tableHeaders returns the texts of the "thead th" header cells of the
+// table of row
+func tableHeaders(row *goquery.Selection) []string {
+	headers := []string{}
+	row.Closest("table").Find("thead th").Each(func(_ int, th *goquery.Selection) {
+		headers = append(headers, strings.TrimSpace(th.Text()))
+	})
+	return headers
+}
+
+// tableCell returns the cell of row in the column with the header text
+// column. The selection is empty if there is no such column.
+func tableCell(row *goquery.Selection, column string) *goquery.Selection {
+	for i, header := range tableHeaders(row) {
+		if strings.EqualFold(strings.Join(strings.Fields(header), " "), column) {
+			return row.First().ChildrenFiltered("td, th").Eq(i)
+		}
+	}
+	return row.Slice(0, 0)
+}
+
+// unmarshalRows appends a map to attrV for every table row of matches.
+// The cells of a row are keyed by the text of the "thead th" header
+// cell of their column.
+func (u *unmarshaller) unmarshalRows(matches *goquery.Selection, htmlAttr string, attrV reflect.Value, attrT reflect.StructField) error {
+	t := attrV.Type().Elem()
+	var err error
+	matches.EachWithBreak(func(i int, row *goquery.Selection) bool {
+		headers := tableHeaders(row)
+		m := reflect.MakeMap(t)
+		row.ChildrenFiltered("td, th").EachWithBreak(func(j int, cell *goquery.Selection) bool {
+			if j >= len(headers) || headers[j] == "" {
+				return true
+			}
+			k := reflect.New(t.Key()).Elem()
+			k.SetString(headers[j])
+			v := reflect.New(t.Elem()).Elem()
+			if err = u.setScalar(v, cell, htmlAttr, attrT); err != nil {
+				err = errors.New("Invalid cell " + headers[j] + " of row " + strconv.Itoa(i) + " of field " + attrT.Name + ": " + err.Error())
+				return false
+			}
+			m.SetMapIndex(k, v)
+			return true
+		})
+		if err != nil {
+			return false
+		}
+		attrV.Set(reflect.Append(attrV, m))
+		return true
+	})
+	return err
+}
+
+// unmarshalMap fills a map field keyed by the "keyAttr" attribute or the
+// text of the "keySelector" child of each matching element
+func (u *unmarshaller) unmarshalMap(s *goquery.Selection, selector, htmlAttr string, attrV reflect.Value, attrT reflect.StructField) error {
+	t := attrV.Type()
+	if t.Key().Kind() != reflect.String {
+		return errors.New("Invalid map key type: " + t.Key().String())
+	}
+	keyAttr := attrT.Tag.Get("keyAttr")
+	keySelector := attrT.Tag.Get("keySelector")
+	if keyAttr == "" && keySelector == "" {
+		return errors.New("Missing keyAttr or keySelector of map field " + attrT.Name)
+	}
+	e := t.Elem()
+	isPtr := e.Kind() == reflect.Ptr && e.Elem().Kind() == reflect.Struct
+	if !isScalar(e) && e.Kind() != reflect.Struct && !isPtr {
+		return errors.New("Invalid map type: " + t.String())
+	}
+	if attrV.IsNil() {
+		attrV.Set(reflect.MakeMap(t))
+	}
+	errorOnDuplicate := attrT.Tag.Get("duplicate") == "error"
+	var err error
+	findMatches(s, selector, attrT).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		var key string
+		if keyAttr != "" {
+			key = strings.TrimSpace(s.AttrOr(keyAttr, ""))
+		} else {
+			key = strings.TrimSpace(s.Find(keySelector).First().Text())
+		}
+		if key == "" {
+			return true
+		}
+		k := reflect.New(t.Key()).Elem()
+		k.SetString(key)
+		if errorOnDuplicate && attrV.MapIndex(k).IsValid() {
+			err = errors.New("Duplicate key of map field " + attrT.Name + ": " + key)
+			return false
+		}
+		var v reflect.Value
+		switch {
+		case isScalar(e):
+			v = reflect.New(e).Elem()
+			err = setValue(v, getDOMValue(s, htmlAttr))
+		case isPtr:
+			v = reflect.New(e.Elem())
+			err = u.unmarshal(v.Interface(), s, i)
+		default:
+			v = reflect.New(e)
+			err = u.unmarshal(v.Interface(), s, i)
+			v = v.Elem()
+		}
+		if err == ErrIncompleteGroup {
+			err = nil
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		attrV.SetMapIndex(k, v)
+		return true
+	})
+	return err
+}
+
+// findMatches returns the elements of s matching the selector and the
+// "filter" and "contains" tags of a field
+func findMatches(s *goquery.Selection, selector string, attrT reflect.StructField) *goquery.Selection {
+	sel := s.Find(selector)
+	if filter := attrT.Tag.Get("filter"); filter != "" {
+		sel = sel.Filter(filter)
+	}
+	if contains := attrT.Tag.Get("contains"); contains != "" {
+		ignoreCase := attrT.Tag.Get("containsIgnoreCase") == "true"
+		if ignoreCase {
+			contains = strings.ToLower(contains)
+		}
+		sel = sel.FilterFunction(func(_ int, e *goquery.Selection) bool {
+			text := e.Text()
+			if ignoreCase {
+				text = strings.ToLower(text)
+			}
+			return strings.Contains(text, contains)
+		})
+	}
+	return sel
+}
+
+// selectScalar returns the elements of s matching the selector of a
+// scalar field. The "index" tag narrows the selection to a single element.
+func selectScalar(s *goquery.Selection, selector string, attrT reflect.StructField) (*goquery.Selection, error) {
+	sel := findMatches(s, selector, attrT)
+	if index := attrT.Tag.Get("index"); index != "" {
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, errors.New("Invalid index value: " + index)
+		}
+		sel = sel.Eq(i)
+	}
+	return sel, nil
+}
+
+// fieldValue returns the extracted and transformed string value of
+// a scalar field
+func (u *unmarshaller) fieldValue(s *goquery.Selection, htmlAttr string, attrT reflect.StructField) (string, error) {
+	val, err := u.extractValue(s, htmlAttr, attrT)
+	if err != nil {
+		return "", err
+	}
+	if fallback := attrT.Tag.Get("fallback"); fallback != "" && val == "" && s.Length() > 0 {
+		if s, err = fallbackSelection(s, fallback); err != nil {
+			return "", err
+		}
+		if val, err = u.extractValue(s, htmlAttr, attrT); err != nil {
+			return "", err
+		}
+	}
+	return u.transformValue(s, val, attrT)
+}
+
+// fallbackSelection returns the ancestor of the first element of s
+// selected by the "fallback" tag
+func fallbackSelection(s *goquery.Selection, fallback string) (*goquery.Selection, error) {
+	if fallback == "parent" {
+		return s.First().Parent(), nil
+	}
+	if strings.HasPrefix(fallback, "closest:") && len(fallback) > len("closest:") {
+		return s.First().Parent().Closest(fallback[len("closest:"):]), nil
+	}
+	return nil, errors.New("Invalid fallback value: " + fallback)
+}
+
+// extractValue returns the value of s selected by the "attr" and
+// "extract" tags
+func (u *unmarshaller) extractValue(s *goquery.Selection, htmlAttr string, attrT reflect.StructField) (string, error) {
+	val := getDOMValue(s, htmlAttr)
+	switch extract := attrT.Tag.Get("extract"); extract {
+	case "", "text":
+	case "rawText":
+		if htmlAttr == "" {
+			val = u.rawText(s)
+		}
+	case "innerHTML", "outerHTML":
+		if htmlAttr != "" || s.Length() == 0 {
+			break
+		}
+		var err error
+		if extract == "innerHTML" {
+			val, err = s.First().Html()
+		} else {
+			val, err = goquery.OuterHtml(s.First())
+		}
+		if err != nil {
+			return "", err
+		}
+	case "selectedOption", "selectedOptionText":
+		opt := s.First()
+		if goquery.NodeName(opt) != "option" {
+			opt = selectedOptions(opt).First()
+		}
+		val = strings.Join(strings.Fields(opt.Text()), " ")
+		if value, ok := opt.Attr("value"); ok && extract == "selectedOption" {
+			val = value
+		}
+	case "email", "phone":
+		matches := findPatterns(val, extract)
+		val = ""
+		if len(matches) > 0 {
+			val = matches[0]
+		}
+	default:
+		return "", errors.New("Invalid extract value: " + extract)
+	}
+	return val, nil
+}
+
+// transformValue applies the tags transforming the extracted value val
+// of s
+func (u *unmarshaller) transformValue(s *goquery.Selection, val string, attrT reflect.StructField) (string, error) {
+	if pick := attrT.Tag.Get("srcsetPick"); pick != "" {
+		if pick != "largest" && pick != "smallest" {
+			return "", errors.New("Invalid srcsetPick value: " + pick)
+		}
+		val = pickSrcset(val, pick == "largest")
+		if val == "" {
+			val = getDOMValue(s, "src")
+		}
+	}
+	if prop := attrT.Tag.Get("css"); prop != "" {
+		style, _ := s.Attr("style")
+		val = cssPropertyValue(style, prop)
+	}
+	if classMap := attrT.Tag.Get("classMap"); classMap != "" {
+		val = mapClass(s.First(), classMap)
+	}
+	if pipe := attrT.Tag.Get("pipe"); pipe != "" {
+		var err error
+		if val, err = u.applyPipe(val, pipe); err != nil {
+			return "", err
+		}
+	}
+	if maxLen := attrT.Tag.Get("maxlen"); maxLen != "" {
+		n, err := strconv.Atoi(maxLen)
+		if err != nil || n < 0 {
+			return "", errors.New("Invalid maxlen value: " + maxLen)
+		}
+		val = truncateText(val, n)
+	}
+	if attrT.Tag.Get("locale") != "" || attrT.Tag.Get("decimal") != "" || attrT.Tag.Get("thousands") != "" {
+		decimal, thousands := ".", ","
+		if locale := attrT.Tag.Get("locale"); locale != "" {
+			seps, ok := numberLocales[strings.ToLower(locale)]
+			if !ok {
+				return "", errors.New("Unknown locale: " + locale)
+			}
+			decimal, thousands = seps[0], seps[1]
+		}
+		if d := attrT.Tag.Get("decimal"); d != "" {
+			decimal = d
+		}
+		if t := attrT.Tag.Get("thousands"); t != "" {
+			thousands = t
+		} else if thousands == decimal {
+			thousands = ","
+			if decimal == "," {
+				thousands = "."
+			}
+		}
+		if decimal == thousands {
+			return "", errors.New("Equal decimal and thousands separators: " + decimal)
+		}
+		val = normalizeNumber(val, decimal, thousands)
+	}
+	if enum := attrT.Tag.Get("enum"); enum != "" {
+		mapped, ok := mapEnum(val, enum)
+		if !ok {
+			return "", errors.New("Unknown enum value: " + val)
+		}
+		val = mapped
+	}
+	return val, nil
+}
+
+// numberLocales contains the decimal and the thousands separators
+// of the locales supported by the "locale" tag
+var numberLocales = map[string][2]string{
+	"en": {".", ","},
+	"ja": {".", ","},
+	"zh": {".", ","},
+	"ko": {".", ","},
+	"de": {",", "."},
+	"es": {",", "."},
+	"it": {",", "."},
+	"nl": {",", "."},
+	"pt": {",", "."},
+	"da": {",", "."},
+	"tr": {",", "."},
+	"id": {",", "."},
+	"fr": {",", " "},
+	"ru": {",", " "},
+	"pl": {",", " "},
+	"cs": {",", " "},
+	"sv": {",", " "},
+	"fi": {",", " "},
+	"no": {",", " "},
+}
+
+// normalizeNumber removes the thousands separators of val and replaces
+// its decimal separator by ".", so it can be parsed by strconv.
+// Space thousands separators match every kind of Unicode space.
+func normalizeNumber(val, decimal, thousands string) string {
+	val = strings.TrimSpace(val)
+	if strings.TrimSpace(thousands) == "" {
+		val = strings.Join(strings.FieldsFunc(val, unicode.IsSpace), "")
+	} else {
+		val = strings.Replace(val, thousands, "", -1)
+	}
+	return strings.Replace(val, decimal, ".", -1)
+}
+
+// mapEnum returns the value of val in an "enum" tag. The value of the
+// "*" key is returned for unknown values if it is present.
+func mapEnum(val, enum string) (string, bool) {
+	val = strings.TrimSpace(val)
+	def, hasDef := "", false
+	for _, pair := range strings.Split(enum, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k := strings.TrimSpace(kv[0])
+		if k == val {
+			return strings.TrimSpace(kv[1]), true
+		}
+		if k == "*" {
+			def, hasDef = strings.TrimSpace(kv[1]), true
+		}
+	}
+	return def, hasDef
+}
+
+// applyPipe runs the steps of a "pipe" tag on val
+func (u *unmarshaller) applyPipe(val, pipe string) (string, error) {
+	for _, step := range splitPipe(pipe) {
+		name, arg := step, ""
+		if i := strings.Index(step, ":"); i != -1 {
+			name, arg = step[:i], step[i+1:]
+		}
+		f, ok := u.transforms[name]
+		if !ok {
+			f, ok = builtinTransforms[name]
+		}
+		if !ok {
+			return "", errors.New("Unknown transform: " + name)
+		}
+		var err error
+		if val, err = f(val, arg); err != nil {
+			return "", err
+		}
+	}
+	return val, nil
+}
+
+// splitPipe splits a "pipe" tag to steps by unescaped "|" characters
+func splitPipe(pipe string) []string {
+	steps := []string{}
+	step := ""
+	for i := 0; i < len(pipe); i++ {
+		switch {
+		case pipe[i] == '\\' && i+1 < len(pipe) && pipe[i+1] == '|':
+			step += "|"
+			i++
+		case pipe[i] == '|':
+			steps = append(steps, step)
+			step = ""
+		default:
+			step += pipe[i : i+1]
+		}
+	}
+	return append(steps, step)
+}
+
+// validateField checks v against the comma separated rules
+// of a "validate" tag
+func validateField(v reflect.Value, rules string) error {
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i != -1 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "nonempty":
+			if isEmptyValue(v) {
+				return errors.New("empty value")
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return errors.New("invalid rule: " + rule)
+			}
+			n, ok := validatedSize(v)
+			if !ok {
+				return errors.New("rule " + name + " is not supported for type " + v.Type().String())
+			}
+			if name == "min" && n < limit {
+				return errors.New("value is less than " + arg)
+			}
+			if name == "max" && n > limit {
+				return errors.New("value is greater than " + arg)
+			}
+		case "url":
+			if v.Kind() != reflect.String {
+				return errors.New("rule url is not supported for type " + v.Type().String())
+			}
+			if v.String() == "" {
+				continue
+			}
+			if pu, err := url.Parse(v.String()); err != nil || pu.Scheme == "" || pu.Host == "" {
+				return errors.New("invalid URL: " + v.String())
+			}
+		default:
+			return errors.New("unknown rule: " + rule)
+		}
+	}
 	return nil
 }
 
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// validatedSize returns the number checked by the min and max
+// validation rules: the value of numbers or the length of strings,
+// slices and maps
+func validatedSize(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+// setSpecialInt sets the value of an int field filled by a special
+// attr value like "#index" or "#count"
+func setSpecialInt(attrV reflect.Value, name string, val int) error {
+	switch attrV.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		attrV.SetInt(int64(val))
+	default:
+		return errors.New("Invalid type for " + name + ": " + attrV.String())
+	}
+	return nil
+}
+
+// unmarshalRegexGroups matches pattern against val and sets the fields
+// of the struct attrV named after the named capture groups of pattern
+func unmarshalRegexGroups(val, pattern string, attrV reflect.Value) error {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	m := r.FindStringSubmatch(val)
+	if m == nil {
+		return nil
+	}
+	for i, name := range r.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		f := attrV.FieldByName(name)
+		if !f.IsValid() || !f.CanSet() {
+			return errors.New("Invalid regex group: no settable field named " + name)
+		}
+		if err := setValue(f, m[i]); err != nil {
+			return errors.New("Cannot set field " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// unmarshalDataAttrs sets the fields of the struct attrV to the
+// attributes of s named after the fields with the given prefix
+func unmarshalDataAttrs(s *goquery.Selection, prefix string, attrV reflect.Value) error {
+	if s.Length() == 0 {
+		return nil
+	}
+	t := attrV.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if !isScalar(f.Type) {
+			return errors.New("Invalid type of data attribute field " + f.Name + ": " + f.Type.String())
+		}
+		name := f.Tag.Get("attr")
+		if name == "" {
+			name = prefix + dataAttrName(f.Name)
+		}
+		val, ok := s.Attr(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(attrV.Field(i), strings.TrimSpace(val)); err != nil {
+			return errors.New("Cannot set field " + f.Name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// dataAttrName converts a field name to the dash separated lower case
+// form of data attribute names, e.g. "ProductId" to "product-id"
+func dataAttrName(name string) string {
+	var b bytes.Buffer
+	var prev rune
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+		prev = r
+	}
+	return b.String()
+}
+
+// unmarshalRange splits val at the first sep and sets the Min and Max
+// fields of the struct attrV to the parts. Both fields are set to val
+// if it contains no sep.
+func unmarshalRange(val, sep string, attrV reflect.Value) error {
+	if strings.TrimSpace(val) == "" {
+		return nil
+	}
+	parts := strings.SplitN(val, sep, 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	for i, name := range []string{"Min", "Max"} {
+		f := attrV.FieldByName(name)
+		if !f.IsValid() || !f.CanSet() {
+			return errors.New("Invalid range struct: no settable field named " + name)
+		}
+		part := strings.TrimSpace(parts[i])
+		if f.Type() != durationType {
+			switch f.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64:
+				part = strings.Replace(rangeNumberRegexp.FindString(part), ",", "", -1)
+			}
+		}
+		if err := setValue(f, part); err != nil {
+			return errors.New("Cannot set field " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// unmarshalZip appends a value to every slice field of the struct attrV
+// for each element of rows, so the slices have equal lengths. Missing
+// values are set to the zero value of the slice element or reported as
+// an error if strict is true.
+func (u *unmarshaller) unmarshalZip(rows *goquery.Selection, attrV reflect.Value, strict bool) error {
+	t := attrV.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Type.Kind() != reflect.Slice || !isScalar(f.Type.Elem()) {
+			return errors.New("Invalid zip field " + f.Name + ": only slices of scalar types are supported")
+		}
+		attrV.Field(i).Set(reflect.MakeSlice(f.Type, 0, rows.Length()))
+	}
+	var err error
+	rows.EachWithBreak(func(row int, s *goquery.Selection) bool {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fv := attrV.Field(i)
+			v := reflect.New(f.Type.Elem()).Elem()
+			cell := s.Find(u.fieldSelector(f))
+			if cell.Length() == 0 {
+				if strict {
+					err = errors.New("Missing value of zip field " + f.Name + " in row " + strconv.Itoa(row))
+					return false
+				}
+				fv.Set(reflect.Append(fv, v))
+				continue
+			}
+			var val string
+			if val, err = u.fieldValue(cell, f.Tag.Get("attr"), f); err != nil {
+				return false
+			}
+			if err = setValue(v, val); err != nil {
+				return false
+			}
+			fv.Set(reflect.Append(fv, v))
+		}
+		return true
+	})
+	return err
+}
+
+// unmarshalPairs splits val into key-value pairs and stores them in the
+// map or struct attrV. Struct fields are matched by their "key" tag or,
+// case-insensitively, by their name. Unknown keys are ignored.
+func unmarshalPairs(val, pairSep, kvSep string, attrV reflect.Value) error {
+	t := attrV.Type()
+	if t.Kind() == reflect.Map {
+		if t.Key().Kind() != reflect.String || !isScalar(t.Elem()) {
+			return errors.New("Invalid map type for pairSep: " + t.String())
+		}
+		if attrV.IsNil() {
+			attrV.Set(reflect.MakeMap(t))
+		}
+	}
+	for _, pair := range strings.Split(val, pairSep) {
+		kv := strings.SplitN(pair, kvSep, 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			continue
+		}
+		value := ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		if t.Kind() == reflect.Map {
+			v := reflect.New(t.Elem()).Elem()
+			if err := setValue(v, value); err != nil {
+				return errors.New("Cannot set key " + key + ": " + err.Error())
+			}
+			attrV.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), v)
+			continue
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Tag.Get("key")
+			if name != key && (name != "" || !strings.EqualFold(f.Name, key)) {
+				continue
+			}
+			if err := setValue(attrV.Field(i), value); err != nil {
+				return errors.New("Cannot set field " + f.Name + ": " + err.Error())
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// DecodeJWTPayload returns the claims of the payload of the JSON Web
+// Token token, e.g. the token of an embedded SPA bootstrap state. The
+// signature of the token is not verified.
+func DecodeJWTPayload(token string) (map[string]interface{}, error) {
+	payload, err := jwtPayload(token)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("Invalid JWT payload: " + err.Error())
+	}
+	return claims, nil
+}
+
+// jwtPayload returns the decoded payload segment of a JSON Web Token
+func jwtPayload(token string) ([]byte, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Invalid JWT: expected 3 segments, got " + strconv.Itoa(len(parts)))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("Invalid JWT payload: " + err.Error())
+	}
+	return payload, nil
+}
+
+// decodeBase64 decodes standard or URL-safe base64 with or without
+// padding. Whitespace is ignored.
+func decodeBase64(val string) ([]byte, error) {
+	val = strings.TrimRight(strings.Join(strings.Fields(val), ""), "=")
+	if strings.ContainsAny(val, "-_") {
+		return base64.RawURLEncoding.DecodeString(val)
+	}
+	return base64.RawStdEncoding.DecodeString(val)
+}
+
+// decodeDataURI returns the payload of a "data:" URI. Other values are
+// returned as they are.
+func decodeDataURI(val string) ([]byte, error) {
+	if !strings.HasPrefix(strings.ToLower(val), "data:") {
+		return []byte(val), nil
+	}
+	i := strings.Index(val, ",")
+	if i == -1 {
+		return nil, errors.New("Invalid data URI: missing comma")
+	}
+	meta, data := val[len("data:"):i], val[i+1:]
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		if err != nil {
+			return nil, errors.New("Invalid base64 data URI: " + err.Error())
+		}
+		return b, nil
+	}
+	data, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, errors.New("Invalid data URI: " + err.Error())
+	}
+	return []byte(data), nil
+}
+
+// isScalar reports whether values of t can be set
+// from a single string by setValue
+func isScalar(t reflect.Type) bool {
+	if isTextUnmarshaler(t) || isURLType(t) {
+		return true
+	}
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isBigType reports whether t is big.Int, big.Float or a pointer to them
+func isBigType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == bigIntType || t == bigFloatType
+}
+
+// setBig parses val into the big.Int or big.Float v. Spaces are removed
+// and big.Float values get enough precision to hold all the digits of
+// val. Empty strings leave v unchanged.
+func setBig(v reflect.Value, val string) error {
+	val = strings.Join(strings.Fields(val), "")
+	if val == "" {
+		return nil
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var parsed reflect.Value
+	if t == bigIntType {
+		n, ok := new(big.Int).SetString(val, 10)
+		if !ok {
+			return errors.New("invalid integer: " + val)
+		}
+		parsed = reflect.ValueOf(n)
+	} else {
+		prec := uint(len(val)) * 4
+		if prec < 64 {
+			prec = 64
+		}
+		f, _, err := big.ParseFloat(val, 10, prec, big.ToNearestEven)
+		if err != nil {
+			return err
+		}
+		parsed = reflect.ValueOf(f)
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(parsed)
+	} else {
+		v.Set(parsed.Elem())
+	}
+	return nil
+}
+
+// setValue converts val to the type of v and stores it in v.
+// Empty strings leave numeric and boolean values unchanged.
+func setValue(v reflect.Value, val string) error {
+	if isTextUnmarshaler(v.Type()) {
+		return unmarshalText(val, v)
+	}
+	if v.Kind() == reflect.String {
+		v.SetString(val)
+		return nil
+	}
+	if v.Kind() == reflect.Interface {
+		v.Set(reflect.ValueOf(inferValue(val)))
+		return nil
+	}
+	if val == "" {
+		return nil
+	}
+	if isURLType(v.Type()) {
+		parsed, err := url.Parse(val)
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Ptr {
+			v.Set(reflect.ValueOf(parsed))
+		} else {
+			v.Set(reflect.ValueOf(*parsed))
+		}
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := parseDuration(val, "")
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(val, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(val, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return errors.New("Invalid type: " + v.String())
+	}
+	return nil
+}
+
+// isURLType reports whether t is url.URL or *url.URL
+func isURLType(t reflect.Type) bool {
+	return t == urlType || (t.Kind() == reflect.Ptr && t.Elem() == urlType)
+}
+
+// resolveURL resolves val by Request.AbsoluteURL, so the <base href> of
+// the response is respected. val is returned unchanged if it is empty,
+// a fragment or invalid.
+func (u *unmarshaller) resolveURL(val string) string {
+	if val == "" || u.request == nil {
+		return val
+	}
+	if abs := u.request.AbsoluteURL(val); abs != "" {
+		return abs
+	}
+	return val
+}
+
+// parseDuration parses val by the given duration format. The empty
+// format accepts the values of time.ParseDuration with optional spaces
+// between the units, e.g. "2h 30m".
+func parseDuration(val, format string) (time.Duration, error) {
+	val = strings.TrimSpace(val)
+	switch format {
+	case "":
+		return time.ParseDuration(strings.Replace(val, " ", "", -1))
+	case "clock":
+		parts := strings.Split(val, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return 0, errors.New("Invalid clock duration: " + val)
+		}
+		d := time.Duration(0)
+		for _, p := range parts {
+			n, err := strconv.ParseUint(p, 10, 32)
+			if err != nil {
+				return 0, errors.New("Invalid clock duration: " + val)
+			}
+			d = d*60 + time.Duration(n)
+		}
+		return d * time.Second, nil
+	case "words":
+		matches := durationWordRegexp.FindAllStringSubmatch(val, -1)
+		if len(matches) == 0 {
+			return 0, errors.New("Invalid duration: " + val)
+		}
+		d := time.Duration(0)
+		for _, m := range matches {
+			n, _ := strconv.ParseFloat(m[1], 64)
+			unit := time.Second
+			switch strings.ToLower(m[2])[0] {
+			case 'd':
+				unit = 24 * time.Hour
+			case 'h':
+				unit = time.Hour
+			case 'm':
+				unit = time.Minute
+			}
+			d += time.Duration(n * float64(unit))
+		}
+		return d, nil
+	}
+	return 0, errors.New("Unknown duration format: " + format)
+}
+
+// inferValue converts val to float64 if it looks like a number, to bool
+// if it is "true" or "false" and returns it as a string otherwise
+func inferValue(val string) interface{} {
+	if f, err := strconv.ParseFloat(val, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	switch strings.ToLower(val) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return val
+}
+
+func isTextUnmarshaler(t reflect.Type) bool {
+	return t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// unmarshalText sets v using its UnmarshalText method.
+// Nil pointers are allocated before unmarshalling.
+func unmarshalText(val string, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(val))
+	}
+	return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(val))
+}
+
 func getDOMValue(s *goquery.Selection, attr string) string {
 	if attr == "" {
 		return strings.TrimSpace(s.First().Text())
 	}
-	attrV, _ := s.Attr(attr)
+	if attr == "#depth" {
+		if s.Length() == 0 {
+			return ""
+		}
+		return strconv.Itoa(s.First().Parents().Length())
+	}
+	if attr == "#aria-label" {
+		return ariaLabel(s.First())
+	}
+	if attrV, ok := s.Attr(attr); ok {
+		return attrV
+	}
+	attrV, _ := s.Attr(strings.ToLower(attr))
 	return attrV
 }
+
+// cssPropertyValue returns the value of the CSS property prop of an
+// inline style declaration. url(...) values are unwrapped.
+func cssPropertyValue(style, prop string) string {
+	for _, decl := range strings.Split(style, ";") {
+		i := strings.Index(decl, ":")
+		if i == -1 || !strings.EqualFold(strings.TrimSpace(decl[:i]), prop) {
+			continue
+		}
+		val := strings.TrimSpace(decl[i+1:])
+		val = strings.TrimSpace(strings.TrimSuffix(val, "!important"))
+		if strings.HasPrefix(strings.ToLower(val), "url(") && strings.HasSuffix(val, ")") {
+			val = strings.Trim(strings.TrimSpace(val[4:len(val)-1]), `"'`)
+		}
+		return val
+	}
+	return ""
+}
+
+// pickSrcset returns the URL of the largest or the smallest candidate of
+// a srcset attribute. Candidates without a width or density descriptor
+// count as "1x".
+func pickSrcset(srcset string, largest bool) string {
+	picked, pickedSize := "", 0.0
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		size := 1.0
+		if len(fields) > 1 {
+			d := fields[1]
+			f, err := strconv.ParseFloat(d[:len(d)-1], 64)
+			if err != nil || (d[len(d)-1] != 'w' && d[len(d)-1] != 'x') {
+				continue
+			}
+			size = f
+		}
+		if picked == "" || (largest && size > pickedSize) || (!largest && size < pickedSize) {
+			picked, pickedSize = fields[0], size
+		}
+	}
+	return picked
+}
+
+// mapClass returns the value of the first class of a "classMap"
+// tag present on s
+func mapClass(s *goquery.Selection, classMap string) string {
+	for _, pair := range strings.Split(classMap, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && s.HasClass(strings.TrimSpace(kv[0])) {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+// truncateText cuts s to at most n runes without splitting multi-byte
+// characters. An ellipsis is appended if s was longer than n runes.
+func truncateText(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimRightFunc(string(runes[:n]), unicode.IsSpace) + "…"
+}
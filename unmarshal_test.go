@@ -2,7 +2,14 @@ package colly
 
 import (
 	"bytes"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -56,3 +63,1114 @@ func TestNestedUnmarshal(t *testing.T) {
 		t.Errorf(`Invalid data for Struct.Struct.String: %q, expected "c"`, s.Struct.Struct.String)
 	}
 }
+
+func TestMaxLenUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<p>héllo wörld</p><span>short</span>`)))
+	e := &HTMLElement{
+		DOM: doc.First(),
+	}
+	s := struct {
+		Long  string `selector:"p" maxlen:"7"`
+		Short string `selector:"span" maxlen:"5"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Long != "héllo w…" {
+		t.Errorf(`Invalid data for Long: %q, expected "héllo w…"`, s.Long)
+	}
+	if s.Short != "short" {
+		t.Errorf(`Invalid data for Short: %q, expected "short"`, s.Short)
+	}
+}
+
+func TestTextUnmarshalerUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<p class="ip">127.0.0.1</p><ul><li>10.0.0.1</li><li>10.0.0.2</li></ul>`)))
+	e := &HTMLElement{
+		DOM: doc.First(),
+	}
+	s := struct {
+		IP    net.IP   `selector:"p.ip"`
+		IPPtr *net.IP  `selector:"p.ip"`
+		IPs   []net.IP `selector:"li"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if !s.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf(`Invalid data for IP: %v, expected "127.0.0.1"`, s.IP)
+	}
+	if s.IPPtr == nil || !s.IPPtr.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf(`Invalid data for IPPtr: %v, expected "127.0.0.1"`, s.IPPtr)
+	}
+	if len(s.IPs) != 2 || !s.IPs[1].Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Errorf(`Invalid data for IPs: %v, expected [10.0.0.1 10.0.0.2]`, s.IPs)
+	}
+	s2 := struct {
+		IP net.IP `selector:"li"`
+	}{}
+	doc, _ = goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<li>not an ip</li>`)))
+	if err := UnmarshalHTML(&s2, doc.Selection); err == nil {
+		t.Error("Invalid IP address unmarshalled without error")
+	}
+}
+
+func TestStructSliceIndexUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer(basicTestData))
+	e := &HTMLElement{
+		DOM: doc.First(),
+	}
+	type item struct {
+		Rank int    `attr:"#index"`
+		Text string `selector:"span"`
+	}
+	s := struct {
+		Items    []item  `selector:"li"`
+		ItemPtrs []*item `selector:"li"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if len(s.Items) != 3 || len(s.ItemPtrs) != 3 {
+		t.Fatalf("Invalid number of items: %d and %d, expected 3", len(s.Items), len(s.ItemPtrs))
+	}
+	for i := range s.Items {
+		if s.Items[i].Rank != i || s.ItemPtrs[i].Rank != i {
+			t.Errorf("Invalid rank for item %d: %d and %d", i, s.Items[i].Rank, s.ItemPtrs[i].Rank)
+		}
+	}
+	if s.Items[0].Text != "item" {
+		t.Errorf(`Invalid data for Items[0].Text: %q, expected "item"`, s.Items[0].Text)
+	}
+}
+
+func TestPipeUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<p> Hello_World </p><span>Price: 42 USD</span>`)))
+	c := NewCollector()
+	c.RegisterTransform("reverse", func(v, _ string) (string, error) {
+		r := []rune(v)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return string(r), nil
+	})
+	e := &HTMLElement{
+		DOM:     doc.First(),
+		Request: &Request{collector: c},
+	}
+	s := struct {
+		Slug     string `selector:"p" pipe:"trim|lower|replace:_,-"`
+		Title    string `selector:"p" pipe:"replace:_, |title"`
+		Price    string `selector:"span" pipe:"regex:(\\d+) (USD\\|EUR)"`
+		Reversed string `selector:"span" pipe:"regex:\\d+|reverse"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Slug != "hello-world" {
+		t.Errorf(`Invalid data for Slug: %q, expected "hello-world"`, s.Slug)
+	}
+	if s.Title != "Hello World" {
+		t.Errorf(`Invalid data for Title: %q, expected "Hello World"`, s.Title)
+	}
+	if s.Price != "42" {
+		t.Errorf(`Invalid data for Price: %q, expected "42"`, s.Price)
+	}
+	if s.Reversed != "24" {
+		t.Errorf(`Invalid data for Reversed: %q, expected "24"`, s.Reversed)
+	}
+	s2 := struct {
+		Text string `selector:"p" pipe:"unknown"`
+	}{}
+	if err := e.Unmarshal(&s2); err == nil {
+		t.Error("Unknown transform unmarshalled without error")
+	}
+	c.Clone().RegisterTransform("unknown", func(v, _ string) (string, error) {
+		return v, nil
+	})
+	if err := e.Unmarshal(&s2); err == nil {
+		t.Error("Transform registered on a clone was used by its parent")
+	}
+}
+
+func TestRegexGroupsUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<p class="rating">4.5 out of 5</p><ul><li>1</li><li>2</li></ul>`)))
+	e := &HTMLElement{
+		DOM: doc.First(),
+	}
+	s := struct {
+		Rating struct {
+			Score float64
+			Max   int
+		} `selector:".rating" regex:"(?P<Score>\\d\\.\\d) out of (?P<Max>\\d)"`
+		Numbers []int `selector:"li"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Rating.Score != 4.5 || s.Rating.Max != 5 {
+		t.Errorf("Invalid data for Rating: %+v, expected {Score:4.5 Max:5}", s.Rating)
+	}
+	if len(s.Numbers) != 2 || s.Numbers[1] != 2 {
+		t.Errorf("Invalid data for Numbers: %v, expected [1 2]", s.Numbers)
+	}
+}
+
+func TestInterfaceUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<table><tr><td>12.5</td><td>true</td><td>n/a</td></tr></table>`)))
+	e := &HTMLElement{
+		DOM: doc.First(),
+	}
+	s := struct {
+		First interface{}   `selector:"td"`
+		Cells []interface{} `selector:"td"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.First != 12.5 {
+		t.Errorf("Invalid data for First: %#v, expected 12.5", s.First)
+	}
+	if len(s.Cells) != 3 || s.Cells[0] != 12.5 || s.Cells[1] != true || s.Cells[2] != "n/a" {
+		t.Errorf(`Invalid data for Cells: %#v, expected [12.5 true "n/a"]`, s.Cells)
+	}
+}
+
+func TestCSSUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<div style="width: 100px; background-image:url('foo.jpg')"></div>`)))
+	e := &HTMLElement{
+		DOM: doc.First(),
+	}
+	s := struct {
+		Image  string `selector:"div" css:"background-image"`
+		Width  string `selector:"div" css:"width"`
+		Height string `selector:"div" css:"height"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Image != "foo.jpg" {
+		t.Errorf(`Invalid data for Image: %q, expected "foo.jpg"`, s.Image)
+	}
+	if s.Width != "100px" {
+		t.Errorf(`Invalid data for Width: %q, expected "100px"`, s.Width)
+	}
+	if s.Height != "" {
+		t.Errorf(`Invalid data for Height: %q, expected ""`, s.Height)
+	}
+}
+
+func TestValidateUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<h1>Title</h1><span class="price">-5</span><a href="/relative">x</a>`)))
+	valid := struct {
+		Title string   `selector:"h1" validate:"nonempty"`
+		Items []string `selector:"h1" validate:"min=1,max=1"`
+	}{}
+	if err := UnmarshalHTML(&valid, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	invalids := []interface{}{
+		&struct {
+			Missing string `selector:"h2" validate:"nonempty"`
+		}{},
+		&struct {
+			Price int `selector:".price" validate:"min=0"`
+		}{},
+		&struct {
+			Link string `selector:"a" attr:"href" validate:"url"`
+		}{},
+	}
+	for _, v := range invalids {
+		err := UnmarshalHTML(v, doc.Selection)
+		if err == nil {
+			t.Errorf("Invalid data unmarshalled without error: %+v", v)
+			continue
+		}
+		field := reflect.TypeOf(v).Elem().Field(0).Name
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Error %q does not name field %s", err, field)
+		}
+	}
+}
+
+func TestClassMapUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<span class="badge badge--expired"></span><p class="other"></p>`)))
+	s := struct {
+		Status  string `selector:".badge" classMap:"badge--active=active,badge--expired=expired"`
+		Expired bool   `selector:".badge" classMap:"badge--expired=true"`
+		Missing string `selector:"p" classMap:"badge--active=active"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Status != "expired" || !s.Expired || s.Missing != "" {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestMapUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer([]byte(`<div class="product" data-sku="a1"><h2>Apple</h2><span>1.5</span></div><div class="product" data-sku="b2"><h2>Banana</h2><span class="sale">0.5</span></div>`)))
+	type product struct {
+		Name  string  `selector:"h2"`
+		Price float64 `selector:"span"`
+	}
+	s := struct {
+		Products map[string]product  `selector:".product" keyAttr:"data-sku"`
+		Ptrs     map[string]*product `selector:".product" keySelector:"h2"`
+		Prices   map[string]float64  `selector:".product span" keyAttr:"class"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if len(s.Products) != 2 || s.Products["b2"].Name != "Banana" || s.Products["a1"].Price != 1.5 {
+		t.Errorf("Invalid data for Products: %+v", s.Products)
+	}
+	if len(s.Ptrs) != 2 || s.Ptrs["Apple"] == nil || s.Ptrs["Apple"].Price != 1.5 {
+		t.Errorf("Invalid data for Ptrs: %+v", s.Ptrs)
+	}
+	if len(s.Prices) != 1 || s.Prices["sale"] != 0.5 {
+		t.Errorf("Invalid data for Prices: %+v", s.Prices)
+	}
+	dup := struct {
+		Products map[string]product `selector:".product" keyAttr:"class" duplicate:"error"`
+	}{}
+	if err := UnmarshalHTML(&dup, doc.Selection); err == nil {
+		t.Error("Duplicate map key unmarshalled without error")
+	}
+}
+
+func TestIndexUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer(basicTestData))
+	s := struct {
+		Second     string `selector:"li" index:"1"`
+		Last       string `selector:"li" index:"-1"`
+		OutOfRange string `selector:"li" index:"5"`
+		LastNumber int    `selector:"li" index:"-1"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Second != "list item 2" || s.Last != "3" || s.OutOfRange != "" || s.LastNumber != 3 {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestSrcsetUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div>
+<img id="w" src="small.jpg" srcset="medium.jpg 800w, large.jpg 1600w, small.jpg 400w">
+<img id="x" srcset="a.jpg, b.jpg 2x">
+<img id="src" src="only.jpg">
+</div>`))
+	s := struct {
+		Largest  string `selector:"#w" attr:"srcset" srcsetPick:"largest"`
+		Smallest string `selector:"#w" attr:"srcset" srcsetPick:"smallest"`
+		Density  string `selector:"#x" attr:"srcset" srcsetPick:"largest"`
+		Fallback string `selector:"#src" attr:"srcset" srcsetPick:"largest"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Largest != "large.jpg" || s.Smallest != "small.jpg" || s.Density != "b.jpg" || s.Fallback != "only.jpg" {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestCountUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer(basicTestData))
+	s := struct {
+		Items   int `selector:"li" attr:"#count"`
+		Missing int `selector:"table" attr:"#count"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Items != 3 || s.Missing != 0 {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestNestedSliceUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div id="nav">
+<a href="/1">1</a>
+<ul><li><a href="/2">2</a><ul><li><a href="/3">3</a></li></ul></li></ul>
+<div><div><p><a href="/4">4</a></p></div></div>
+</div><a href="/outside">outside</a>`))
+	s := struct {
+		Links []string `selector:"a" attr:"href"`
+		Items []string `selector:"li"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Find("#nav")); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if !reflect.DeepEqual(s.Links, []string{"/1", "/2", "/3", "/4"}) {
+		t.Errorf("Invalid links: %v", s.Links)
+	}
+	if !reflect.DeepEqual(s.Items, []string{"23", "3"}) {
+		t.Errorf("Invalid nested items: %v", s.Items)
+	}
+}
+
+func TestPairsUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div id="item" data-info="color=red; size=42;in-stock=true"></div>`))
+	s := struct {
+		Info struct {
+			Color   string
+			Size    int
+			InStock bool `key:"in-stock"`
+		} `selector:"#item" attr:"data-info" pairSep:";"`
+		Raw map[string]string `selector:"#item" attr:"data-info" pairSep:";" kvSep:"="`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Info.Color != "red" || s.Info.Size != 42 || !s.Info.InStock {
+		t.Errorf("Invalid struct data: %+v", s.Info)
+	}
+	if len(s.Raw) != 3 || s.Raw["size"] != "42" {
+		t.Errorf("Invalid map data: %v", s.Raw)
+	}
+}
+
+func TestRawTextUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<p>"Fish" &amp; Chips&nbsp;&lt;b&gt;</p>`))
+	s := struct {
+		Text    string `selector:"p"`
+		RawText string `selector:"p" extract:"rawText"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Text != "\"Fish\" & Chips\u00a0<b>" || s.RawText != `"Fish" &amp; Chips&nbsp;&lt;b&gt;` {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestJSONAttrUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div id="app" data-props='{"id":42,"tags":["a","b"]}'></div><div id="broken" data-props="{"></div>`))
+	s := struct {
+		Props struct {
+			ID   int      `json:"id"`
+			Tags []string `json:"tags"`
+		} `selector:"#app" attr:"data-props" decode:"json"`
+		Raw  map[string]interface{} `selector:"#app" attr:"data-props" decode:"json"`
+		Tags []string               `selector:"#missing" attr:"data-props" decode:"json"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Props.ID != 42 || len(s.Props.Tags) != 2 || s.Raw["id"] != float64(42) || s.Tags != nil {
+		t.Errorf("Invalid data: %+v", s)
+	}
+	broken := struct {
+		Props map[string]string `selector:"#broken" attr:"data-props" decode:"json"`
+	}{}
+	if err := UnmarshalHTML(&broken, doc.Selection); err == nil || !strings.Contains(err.Error(), "Props") {
+		t.Errorf("Invalid error for broken JSON: %v", err)
+	}
+}
+
+func TestDataURIUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<img id="b64" src="data:image/png;base64,aGVsbG8="><img id="plain" src="data:text/plain,a%20b"><img id="url" src="/img.png">`))
+	s := struct {
+		Base64 []byte `selector:"#b64" attr:"src"`
+		Plain  []byte `selector:"#plain" attr:"src"`
+		URL    []byte `selector:"#url" attr:"src"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if string(s.Base64) != "hello" || string(s.Plain) != "a b" || string(s.URL) != "/img.png" {
+		t.Errorf("Invalid data: %q %q %q", s.Base64, s.Plain, s.URL)
+	}
+}
+
+func TestBase64JWTUnmarshal(t *testing.T) {
+	token := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiI0MiIsIm5hbWUiOiJKw6RuZSIsImFkbWluIjp0cnVlfQ.c2ln"
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div id="app" data-state="aGVsbG8sIHdvcmxkPz4=" data-bin="-_8" data-token="` + token + `"></div><i data-bad="a$b"></i>`))
+	s := struct {
+		State  string `selector:"#app" attr:"data-state" decode:"base64"`
+		Binary []byte `selector:"#app" attr:"data-bin" decode:"base64"`
+		Claims struct {
+			Subject string `json:"sub"`
+			Name    string `json:"name"`
+		} `selector:"#app" attr:"data-token" decode:"jwt"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Fatal("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.State != "hello, world?>" || !bytes.Equal(s.Binary, []byte{0xfb, 0xff}) {
+		t.Errorf("Invalid base64 data: %q %v", s.State, s.Binary)
+	}
+	if s.Claims.Subject != "42" || s.Claims.Name != "Jäne" {
+		t.Errorf("Invalid JWT claims: %+v", s.Claims)
+	}
+
+	claims, err := DecodeJWTPayload(token)
+	if err != nil || claims["sub"] != "42" || claims["admin"] != true {
+		t.Errorf("Invalid JWT payload: %v %v", claims, err)
+	}
+	if _, err := DecodeJWTPayload("not a token"); err == nil {
+		t.Error("Invalid token must return an error")
+	}
+
+	bad := struct {
+		Value string `selector:"i" attr:"data-bad" decode:"base64"`
+	}{}
+	if err := UnmarshalHTML(&bad, doc.Selection); err == nil || !strings.Contains(err.Error(), "Value") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestHTMLExtractUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div class="card" data-id="1"><b>Bold</b> text</div>`))
+	s := struct {
+		Inner string `selector:".card" extract:"innerHTML"`
+		Outer string `selector:".card" extract:"outerHTML"`
+		None  string `selector:".missing" extract:"outerHTML"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Inner != "<b>Bold</b> text" || s.Outer != `<div class="card" data-id="1"><b>Bold</b> text</div>` || s.None != "" {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestZipUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<table>
+<tr class="row"><td class="name">A</td><td class="price">1</td></tr>
+<tr class="row"><td class="name">B</td></tr>
+<tr class="row"><td class="name">C</td><td class="price">3</td></tr>
+</table>`))
+	type columns struct {
+		Names  []string `selector:".name"`
+		Prices []int    `selector:".price"`
+	}
+	s := struct {
+		Table columns `selector:".row" zip:"pad"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if !reflect.DeepEqual(s.Table.Names, []string{"A", "B", "C"}) || !reflect.DeepEqual(s.Table.Prices, []int{1, 0, 3}) {
+		t.Errorf("Invalid data: %+v", s.Table)
+	}
+	strict := struct {
+		Table columns `selector:".row" zip:"error"`
+	}{}
+	if err := UnmarshalHTML(&strict, doc.Selection); err == nil || !strings.Contains(err.Error(), "row 1") {
+		t.Errorf("Invalid error for missing value: %v", err)
+	}
+}
+
+func TestSelectionFieldUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewBuffer(basicTestData))
+	type item struct {
+		Node *goquery.Selection
+	}
+	s := struct {
+		Items []item             `selector:"li"`
+		List  *goquery.Selection `selector:"ul"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if len(s.Items) != 3 || s.Items[1].Node == nil || s.Items[1].Node.Text() != "list item 2" {
+		t.Errorf("Invalid item nodes: %+v", s.Items)
+	}
+	if s.List == nil || s.List.Length() != 1 {
+		t.Errorf("Invalid list selection: %v", s.List)
+	}
+}
+
+type testStatus int
+
+const (
+	testStatusUnknown testStatus = iota
+	testStatusActive
+	testStatusExpired
+)
+
+func TestEnumUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<span class="a">active</span><span class="b"> expired </span><span class="c">sold</span>`))
+	s := struct {
+		A testStatus `selector:".a" enum:"active=1,expired=2"`
+		B testStatus `selector:".b" enum:"active=1,expired=2"`
+		C testStatus `selector:".c" enum:"active=1,expired=2,*=0"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.A != testStatusActive || s.B != testStatusExpired || s.C != testStatusUnknown {
+		t.Errorf("Invalid data: %+v", s)
+	}
+	strict := struct {
+		C testStatus `selector:".c" enum:"active=1,expired=2"`
+	}{}
+	if err := UnmarshalHTML(&strict, doc.Selection); err == nil {
+		t.Error("Unknown enum value did not return an error")
+	}
+}
+
+func TestLocaleNumberUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<span class="de">1.234,56</span><span class="en">1,234.56</span><span class="fr">1&nbsp;234,56</span><span class="short">1,5</span>`))
+	s := struct {
+		DE       float64 `selector:".de" locale:"de"`
+		EN       float64 `selector:".en" locale:"en"`
+		FR       float64 `selector:".fr" locale:"fr"`
+		Explicit float64 `selector:".de" decimal:"," thousands:"."`
+		Int      int     `selector:".en" decimal:"." thousands:"," pipe:"regex:^([\\d,]+)"`
+		Comma    float64 `selector:".short" decimal:","`
+		Grouped  float64 `selector:".de" decimal:","`
+		English  float64 `selector:".short" locale:"en" decimal:","`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.DE != 1234.56 || s.EN != 1234.56 || s.FR != 1234.56 || s.Explicit != 1234.56 || s.Int != 1234 {
+		t.Errorf("Invalid data: %+v", s)
+	}
+	if s.Comma != 1.5 || s.Grouped != 1234.56 || s.English != 1.5 {
+		t.Errorf("Invalid data of decimal commas: %+v", s)
+	}
+	equal := struct {
+		Value float64 `selector:".short" decimal:"," thousands:","`
+	}{}
+	if err := UnmarshalHTML(&equal, doc.Selection); err == nil {
+		t.Error("Equal decimal and thousands separators did not return an error")
+	}
+}
+
+func TestUnitUnmarshal(t *testing.T) {
+	RegisterUnit("stone", "g", 6350.29318)
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<ul>
+<li class="kg">2.5 kg</li><li class="lbs">3 lbs</li><li class="plain">750</li>
+<li class="de">1,5 km</li><li class="stone">2 Stone</li><li class="bad">3 parsecs</li>
+</ul>`))
+	s := struct {
+		KG     float64   `selector:".kg" unit:"g"`
+		LBS    int       `selector:".lbs" unit:"g"`
+		Plain  float64   `selector:".plain" unit:"g"`
+		DE     float64   `selector:".de" unit:"m" locale:"de"`
+		Stone  float64   `selector:".stone" unit:"kg"`
+		Masses []float64 `selector:".kg, .lbs" unit:"kg"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Fatal("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.KG != 2500 || s.LBS != 1361 || s.Plain != 750 || s.DE != 1500 || math.Abs(s.Stone-12.70058636) > 1e-9 {
+		t.Errorf("Invalid data: %+v", s)
+	}
+	if len(s.Masses) != 2 || s.Masses[0] != 2.5 || s.Masses[1] != 1.36077711 {
+		t.Errorf("Invalid masses: %v", s.Masses)
+	}
+
+	bad := struct {
+		Distance float64 `selector:".bad" unit:"m"`
+	}{}
+	if err := UnmarshalHTML(&bad, doc.Selection); err == nil || !strings.Contains(err.Error(), "Distance") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestBigNumberUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<span class="int">123456789012345678901234567890</span><span class="float">1.234.567.890.123.456,789012345678</span><span class="bad">n/a</span>`))
+	s := struct {
+		Int   *big.Int   `selector:".int"`
+		Float *big.Float `selector:".float" locale:"de"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Fatal("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Int == nil || s.Int.String() != "123456789012345678901234567890" {
+		t.Errorf("Invalid big.Int: %v", s.Int)
+	}
+	if s.Float == nil || s.Float.Text('f', 12) != "1234567890123456.789012345678" {
+		t.Errorf("Invalid big.Float: %v", s.Float)
+	}
+
+	bad := struct {
+		Int *big.Int `selector:".bad"`
+	}{}
+	if err := UnmarshalHTML(&bad, doc.Selection); err == nil || !strings.Contains(err.Error(), "Int") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestDurationUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<span class="go">2h 30m</span><span class="clock">1:30:05</span><span class="short">4:05</span><span class="words">1 hour 30 minutes</span><span class="bad">soon</span>`))
+	s := struct {
+		Go    time.Duration `selector:".go"`
+		Clock time.Duration `selector:".clock" format:"clock"`
+		Short time.Duration `selector:".short" format:"clock"`
+		Words time.Duration `selector:".words" format:"words"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Go != 150*time.Minute || s.Clock != 90*time.Minute+5*time.Second || s.Short != 245*time.Second || s.Words != 90*time.Minute {
+		t.Errorf("Invalid data: %+v", s)
+	}
+	bad := struct {
+		Length time.Duration `selector:".bad" format:"words"`
+	}{}
+	if err := UnmarshalHTML(&bad, doc.Selection); err == nil || !strings.Contains(err.Error(), "Length") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestFilterUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<form>
+<input type="checkbox" data-id="a" checked>
+<input type="checkbox" data-id="b">
+<input type="checkbox" data-id="c" checked="checked">
+<select><option value="1">one</option><option value="2" selected>two</option></select>
+</form>`))
+	s := struct {
+		Checked  []string `selector:"input" filter:"[checked]" attr:"data-id"`
+		Count    int      `selector:"input" filter:"[checked]" attr:"#count"`
+		Selected string   `selector:"option" filter:"[selected]" attr:"value"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if !reflect.DeepEqual(s.Checked, []string{"a", "c"}) || s.Count != 2 || s.Selected != "2" {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestSliceTransformUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<a> Go </a><a>HTML  </a><a>css</a><span>1</span><span>x</span>`))
+	s := struct {
+		Tags []string `selector:"a" pipe:"trim|lower"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if !reflect.DeepEqual(s.Tags, []string{"go", "html", "css"}) {
+		t.Errorf("Invalid tags: %q", s.Tags)
+	}
+	bad := struct {
+		Numbers []int `selector:"span"`
+	}{}
+	if err := UnmarshalHTML(&bad, doc.Selection); err == nil || !strings.Contains(err.Error(), "element 1 of field Numbers") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestTableRowsUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<table>
+<thead><tr><th>Name</th><th>Price</th><th></th></tr></thead>
+<tbody>
+<tr><td>Apple</td><td> 1 </td><td>x</td></tr>
+<tr><td>Pear</td><td>2</td></tr>
+</tbody>
+</table>`))
+	s := struct {
+		Rows []map[string]string `selector:"tbody tr"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	expected := []map[string]string{
+		{"Name": "Apple", "Price": "1"},
+		{"Name": "Pear", "Price": "2"},
+	}
+	if !reflect.DeepEqual(s.Rows, expected) {
+		t.Errorf("Invalid rows: %v", s.Rows)
+	}
+}
+
+func TestTableColumnUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<table>
+<thead><tr><th>Price</th><th>Product Name</th><th>Link</th></tr></thead>
+<tbody>
+<tr><td>1.5</td><td>Apple</td><td><a href="/apple">open</a></td></tr>
+<tr><td>2</td><td>Pear</td><td><a href="/pear">open</a></td></tr>
+</tbody>
+</table>`))
+	type row struct {
+		Name    string  `column:"product name"`
+		Price   float64 `column:"Price"`
+		Link    string  `column:"Link" selector:"a" attr:"href"`
+		Missing string  `column:"Stock"`
+	}
+	s := struct {
+		Rows []row `selector:"tbody tr"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	expected := []row{{"Apple", 1.5, "/apple", ""}, {"Pear", 2, "/pear", ""}}
+	if !reflect.DeepEqual(s.Rows, expected) {
+		t.Errorf("Invalid rows: %+v", s.Rows)
+	}
+}
+
+func TestSelectedOptionUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<form>
+<select name="sort"><option value="new">Newest</option><option value="price" selected>Lowest price</option></select>
+<select name="page"><option>1</option><option>2</option></select>
+<select name="tags" multiple><option value="a" selected>A</option><option value="b">B</option><option value="c" selected>C</option></select>
+</form>`))
+	s := struct {
+		Sort     string   `selector:"[name=sort]" extract:"selectedOption"`
+		SortText string   `selector:"[name=sort]" extract:"selectedOptionText"`
+		Page     int      `selector:"[name=page]" extract:"selectedOption"`
+		Tags     []string `selector:"[name=tags]" extract:"selectedOption"`
+		TagNames []string `selector:"[name=tags]" extract:"selectedOptionText"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Sort != "price" || s.SortText != "Lowest price" || s.Page != 1 {
+		t.Errorf("Invalid selected options: %+v", s)
+	}
+	if !reflect.DeepEqual(s.Tags, []string{"a", "c"}) || !reflect.DeepEqual(s.TagNames, []string{"A", "C"}) {
+		t.Errorf("Invalid selected options of multiple select: %v %v", s.Tags, s.TagNames)
+	}
+}
+
+func TestContactExtractUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div class="contact">
+<p>Write to info@example.com or sales.team+eu@mail.example.co.uk.</p>
+<p>Call +1 (555) 123-4567, fax 030-123456 (room 12).</p>
+</div>`))
+	s := struct {
+		Email  string   `selector:".contact" extract:"email"`
+		Emails []string `selector:"p" extract:"email"`
+		Phone  string   `selector:".contact" extract:"phone"`
+		Phones []string `selector:"p" extract:"phone"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Email != "info@example.com" || !reflect.DeepEqual(s.Emails, []string{"info@example.com", "sales.team+eu@mail.example.co.uk"}) {
+		t.Errorf("Invalid emails: %q %q", s.Email, s.Emails)
+	}
+	if s.Phone != "+1 (555) 123-4567" || !reflect.DeepEqual(s.Phones, []string{"+1 (555) 123-4567", "030-123456"}) {
+		t.Errorf("Invalid phones: %q %q", s.Phone, s.Phones)
+	}
+}
+
+func TestRangeUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<span class="price">$10 – $1,250.50</span>
+<span class="single">$7</span>
+<span class="dates">2018-01-02T00:00:00Z to 2018-01-05T00:00:00Z</span>`))
+	type priceRange struct {
+		Min int
+		Max float64
+	}
+	s := struct {
+		Price  priceRange `selector:".price" rangeSep:"–"`
+		Single priceRange `selector:".single" rangeSep:"–"`
+		Dates  struct {
+			Min time.Time
+			Max time.Time
+		} `selector:".dates" rangeSep:"to"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Price.Min != 10 || s.Price.Max != 1250.5 || s.Single.Min != 7 || s.Single.Max != 7 {
+		t.Errorf("Invalid prices: %+v %+v", s.Price, s.Single)
+	}
+	if s.Dates.Min.Day() != 2 || s.Dates.Max.Day() != 5 {
+		t.Errorf("Invalid dates: %+v", s.Dates)
+	}
+}
+
+func TestPresenceUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div class="product"><span class="sale">-10%</span></div>`))
+	s := struct {
+		OnSale     bool `selector:".sale" presence:"true"`
+		OutOfStock bool `selector:".add-to-cart" presence:"true" negate:"true"`
+		Cart       bool `selector:".add-to-cart" presence:"true"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if !s.OnSale || !s.OutOfStock || s.Cart {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestDataAttrsUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div class="product" data-id="42" data-unit-price="9.5" data-sku="x1" data-p-id="7"></div>`))
+	s := struct {
+		Product struct {
+			Id        string
+			UnitPrice float64
+			Code      string `attr:"data-sku"`
+			Missing   string
+		} `selector:".product" dataAttrs:"true"`
+		Prefixed struct {
+			Id int
+		} `selector:".product" dataPrefix:"data-p-"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Product.Id != "42" || s.Product.UnitPrice != 9.5 || s.Product.Code != "x1" || s.Product.Missing != "" || s.Prefixed.Id != 7 {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestTimeSliceUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<ul>
+<li class="event-date">Jan 2, 2018</li>
+<li class="event-date">Mar 15, 2018</li>
+<li class="bad-date">Mar 15, 2018</li>
+<li class="bad-date">soon</li>
+</ul>`))
+	s := struct {
+		First time.Time   `selector:".event-date" format:"Jan 2, 2006"`
+		Dates []time.Time `selector:".event-date" format:"Jan 2, 2006"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.First.Day() != 2 || len(s.Dates) != 2 || s.Dates[1].Month() != time.March || s.Dates[1].Day() != 15 {
+		t.Errorf("Invalid dates: %v %v", s.First, s.Dates)
+	}
+	bad := struct {
+		Dates []time.Time `selector:".bad-date" format:"Jan 2, 2006"`
+	}{}
+	err := UnmarshalHTML(&bad, doc.Selection)
+	if err == nil || !strings.Contains(err.Error(), "element 1 of field Dates") || !strings.Contains(err.Error(), `"soon"`) {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestDecoderJSONSelector(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div class="product"><span class="name">Tea</span><span data-field="price">4.5</span><b>new</b></div>`))
+	product := struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price,omitempty" selector:"[data-field=price]"`
+		Label string  `json:"label" selector:"b"`
+	}{}
+	d := &Decoder{JSONSelector: ".{}"}
+	if err := d.Unmarshal(&product, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if product.Name != "Tea" || product.Price != 4.5 || product.Label != "new" {
+		t.Errorf("Invalid data: %+v", product)
+	}
+}
+
+func TestDiscriminatorUnmarshal(t *testing.T) {
+	type article struct {
+		Title string `selector:"h2"`
+	}
+	type ad struct {
+		Link string `selector:"a" attr:"href"`
+	}
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<ul>
+<li data-type="article"><h2>News</h2></li>
+<li data-type="ad"><a href="/buy">Buy</a></li>
+<li data-type="video"></li>
+</ul>`))
+	feed := struct {
+		Items []interface{} `selector:"li" discriminator:"" discriminatorAttr:"data-type" invalid:"skip"`
+	}{}
+	d := &Decoder{Types: map[string]interface{}{"article": article{}, "ad": &ad{}}}
+	if err := d.Unmarshal(&feed, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	expected := []interface{}{article{"News"}, &ad{"/buy"}}
+	if !reflect.DeepEqual(feed.Items, expected) {
+		t.Errorf("Invalid items: %#v", feed.Items)
+	}
+
+	strict := struct {
+		Items []interface{} `selector:"li" discriminator:"" discriminatorAttr:"data-type"`
+	}{}
+	if err := d.Unmarshal(&strict, doc.Selection); err == nil {
+		t.Error("Unknown discriminator must return an error")
+	}
+}
+
+func TestAriaUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<h2 id="cart-title">Cart</h2>
+<ul role="list" aria-labelledby="cart-title">
+<li role="listitem"><a href="/tea" aria-label="Tea">1</a><span role="status">in stock</span></li>
+<li role="listitem"><a href="/coffee" aria-label="Coffee">2</a><span role="status">sold out</span></li>
+</ul>`))
+	type item struct {
+		Name   string `selector:"a" attr:"ARIA-LABEL"`
+		Status string `selector:"[role=status]"`
+	}
+	s := struct {
+		Title string `selector:"[role=list]" attr:"#aria-label"`
+		Items []item `selector:"[role=listitem]"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	expected := []item{{"Tea", "in stock"}, {"Coffee", "sold out"}}
+	if s.Title != "Cart" || !reflect.DeepEqual(s.Items, expected) {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestGroupUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<ul>
+<li><b>Tea</b><a class="mail">tea@example.com</a><i class="phone">555-0100</i></li>
+<li><b>Coffee</b><a class="mail">coffee@example.com</a></li>
+<li><b>Water</b></li>
+</ul>`))
+	type shop struct {
+		Name  string `selector:"b"`
+		Email string `selector:".mail" group:"contact"`
+		Phone string `selector:".phone" group:"contact"`
+	}
+	s := struct {
+		Shops []shop `selector:"li"`
+	}{}
+	err := UnmarshalHTML(&s, doc.Selection)
+	if err == nil || !strings.Contains(err.Error(), "Phone") {
+		t.Errorf("Invalid error for incomplete group: %v", err)
+	}
+
+	d := &Decoder{DiscardIncompleteGroups: true}
+	s.Shops = nil
+	if err := d.Unmarshal(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	expected := []shop{{"Tea", "tea@example.com", "555-0100"}, {"Water", "", ""}}
+	if !reflect.DeepEqual(s.Shops, expected) {
+		t.Errorf("Invalid shops: %+v", s.Shops)
+	}
+
+	single := shop{Name: "unchanged"}
+	if err := d.Unmarshal(&single, doc.Find("li").Eq(1)); err != ErrIncompleteGroup {
+		t.Errorf("Invalid error: %v, expected %v", err, ErrIncompleteGroup)
+	}
+	if single.Name != "unchanged" {
+		t.Errorf("Discarded value was modified: %+v", single)
+	}
+}
+
+func TestMinItemsUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div class="gallery"><img src="/a.png"><img src="/b.png"></div>`))
+	ok := struct {
+		Images []string `selector:".gallery img" attr:"src" minItems:"2"`
+	}{}
+	if err := UnmarshalHTML(&ok, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+
+	tooFew := struct {
+		Videos []string `selector:".gallery video" attr:"src" minItems:"1"`
+	}{}
+	err := UnmarshalHTML(&tooFew, doc.Selection)
+	if err == nil || err.Error() != "Too few elements of field Videos: 0, expected at least 1" {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestRootUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div id="main"><h1>Tea</h1><span class="price">4.5</span></div>
+<aside id="sidebar"><span class="price">3.9</span><ul><li>green</li><li>black</li></ul></aside>`))
+	s := struct {
+		Name      string   `selector:"h1"`
+		Price     float64  `selector:".price"`
+		SalePrice float64  `root:"#sidebar" selector:".price"`
+		Tags      []string `root:"#sidebar" selector:"li"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Find("#main")); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Name != "Tea" || s.Price != 4.5 || s.SalePrice != 3.9 || !reflect.DeepEqual(s.Tags, []string{"green", "black"}) {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestDepthUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<ul><li>a<ul><li>b</li></ul></li></ul>`))
+	s := struct {
+		Depth  int   `selector:"ul" attr:"#depth"`
+		Depths []int `selector:"li" attr:"#depth"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Depth != 2 || !reflect.DeepEqual(s.Depths, []int{3, 5}) {
+		t.Errorf("Invalid depths: %d %v", s.Depth, s.Depths)
+	}
+}
+
+func TestFallbackUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<div class="price-container" data-price="12"><p data-price="5">Price: <span class="price"></span>10</p></div>`))
+	s := struct {
+		Parent  string `selector:".price" fallback:"parent"`
+		Closest string `selector:".price" attr:"data-price" fallback:"closest:.price-container"`
+		Own     string `selector:"p" attr:"data-price" fallback:"parent"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Parent != "Price: 10" || s.Closest != "12" || s.Own != "5" {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}
+
+func TestURLUnmarshal(t *testing.T) {
+	e := NewTestHTMLElement(`<div><a href="/docs?page=2">Docs</a><a href="http://example.com/x">Ex</a><img src="logo.png"><a class="bad" href="http://[::1">Bad</a></div>`, "div")
+	s := struct {
+		Link     *url.URL   `selector:"a" attr:"href"`
+		Resolved url.URL    `selector:"a" attr:"href" resolve:"true"`
+		Links    []*url.URL `selector:"a:not(.bad)" attr:"href" resolve:"true"`
+		Image    string     `selector:"img" attr:"src" resolve:"true"`
+		Missing  *url.URL   `selector:"video" attr:"src"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Link.Path != "/docs" || s.Link.Host != "" || s.Resolved.String() != "http://localhost/docs?page=2" {
+		t.Errorf("Invalid links: %v %v", s.Link, s.Resolved)
+	}
+	if len(s.Links) != 2 || s.Links[1].Host != "example.com" || s.Image != "http://localhost/logo.png" || s.Missing != nil {
+		t.Errorf("Invalid data: %+v", s)
+	}
+	bad := struct {
+		Link *url.URL `selector:".bad" attr:"href"`
+	}{}
+	if err := e.Unmarshal(&bad); err == nil || !strings.Contains(err.Error(), "field Link") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestURLSliceUnmarshal(t *testing.T) {
+	e := NewTestHTMLElement(`<nav><a href="/a">A</a><a href="http://[::1">Bad</a><a href="b?x=1">B</a></nav>`, "nav")
+	s := struct {
+		Links []*url.URL `selector:"a" attr:"href" resolve:"true" invalid:"skip"`
+	}{}
+	if err := e.Unmarshal(&s); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if len(s.Links) != 2 || s.Links[0].String() != "http://localhost/a" || s.Links[1].String() != "http://localhost/b?x=1" {
+		t.Errorf("Invalid links: %v", s.Links)
+	}
+	strict := struct {
+		Links []*url.URL `selector:"a" attr:"href" resolve:"true"`
+	}{}
+	if err := e.Unmarshal(&strict); err == nil || !strings.Contains(err.Error(), "element 1 of field Links") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestContainsUnmarshal(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<span class="badge">Sale</span><span class="badge">New</span><span class="badge">SALE -20%</span>`))
+	s := struct {
+		Count     int      `selector:".badge" contains:"Sale" attr:"#count"`
+		Badges    []string `selector:".badge" contains:"sale" containsIgnoreCase:"true"`
+		FirstSale string   `selector:".badge" contains:"SALE"`
+	}{}
+	if err := UnmarshalHTML(&s, doc.Selection); err != nil {
+		t.Error("Cannot unmarshal struct: " + err.Error())
+	}
+	if s.Count != 1 || !reflect.DeepEqual(s.Badges, []string{"Sale", "SALE -20%"}) || s.FirstSale != "SALE -20%" {
+		t.Errorf("Invalid data: %+v", s)
+	}
+}